@@ -0,0 +1,7 @@
+package api
+
+// Checksum ...
+type Checksum struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
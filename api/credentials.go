@@ -0,0 +1,8 @@
+package api
+
+// Credentials ...
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
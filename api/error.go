@@ -7,4 +7,6 @@ import (
 type Error struct {
 	Time  time.Time `json:"time"`
 	Error string    `json:"error"`
+	Code  string    `json:"code,omitempty"`
+	Quota string    `json:"quota,omitempty"`
 }
@@ -1,11 +1,22 @@
 package api
 
+import (
+	"time"
+)
+
 // IncomingDownload ...
 type IncomingDownload struct {
-	RequestID    string `json:"request_id"`
-	URL          string `json:"url"`
-	Checksum     string `json:"checksum"`
-	ChecksumType string `json:"checksum_type"`
-	Callback     string `json:"callback"`
-	ETag         string `json:"etag"`
+	RequestID      string            `json:"request_id"`
+	URL            string            `json:"url"`
+	Checksum       string            `json:"checksum"`
+	ChecksumType   string            `json:"checksum_type"`
+	Checksums      []Checksum        `json:"checksums,omitempty"`
+	Callback       string            `json:"callback"`
+	CallbackSecret string            `json:"callback_secret,omitempty"`
+	ETag           string            `json:"etag"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Deadline       time.Time         `json:"deadline,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	CorrelationID  string            `json:"correlation_id,omitempty"`
+	TraceParent    string            `json:"traceparent,omitempty"`
 }
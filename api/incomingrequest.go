@@ -1,9 +1,22 @@
 package api
 
+import (
+	"time"
+)
+
 // IncomingRequest ...
 type IncomingRequest struct {
-	URL          string `json:"url"`
-	Checksum     string `json:"checksum,omitempty"`
-	ChecksumType string `json:"checksum_type,omitempty"`
-	Callback     string `json:"callback,omitempty"`
+	URL          string            `json:"url"`
+	Checksum     string            `json:"checksum,omitempty"`
+	ChecksumType string            `json:"checksum_type,omitempty"`
+	ChecksumURL  string            `json:"checksum_url,omitempty"`
+	Checksums    []Checksum        `json:"checksums,omitempty"`
+	Integrity    string            `json:"integrity,omitempty"`
+	Callback     string            `json:"callback,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Credentials  *Credentials      `json:"credentials,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	NotBefore    time.Time         `json:"not_before,omitempty"`
+	Deadline     time.Time         `json:"deadline,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
 }
@@ -0,0 +1,11 @@
+package api
+
+// IncomingSchedule ...
+type IncomingSchedule struct {
+	URL          string `json:"url"`
+	Cron         string `json:"cron,omitempty"`
+	Interval     string `json:"interval,omitempty"`
+	ChecksumURL  string `json:"checksum_url,omitempty"`
+	ChecksumType string `json:"checksum_type,omitempty"`
+	Callback     string `json:"callback,omitempty"`
+}
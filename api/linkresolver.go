@@ -2,7 +2,7 @@ package api
 
 import (
 	"github.com/gorilla/mux"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
@@ -46,7 +46,7 @@ func (r *LinkResolver) ResolveLink(req *http.Request, link *Link) {
 	if route != nil {
 		u, err := route.URL(link.ValueID, link.Value)
 		if err != nil {
-			log.Print(err)
+			slog.Error("link-resolve-error", "route", link.RouteName, "error", err)
 		} else {
 			u.Host = r.urlHost(req)
 			u.Scheme = r.urlScheme(req)
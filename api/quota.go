@@ -0,0 +1,28 @@
+package api
+
+import (
+	"time"
+)
+
+// Quota ...
+type Quota struct {
+	MaxRequestsPerDay int    `json:"max_requests_per_day,omitempty"`
+	MaxQueued         int    `json:"max_queued,omitempty"`
+	MaxBytesPerDay    uint64 `json:"max_bytes_per_day,omitempty"`
+}
+
+// QuotaUsage ...
+type QuotaUsage struct {
+	Day      time.Time `json:"day"`
+	ResetsAt time.Time `json:"resets_at"`
+	Requests uint64    `json:"requests"`
+	Bytes    uint64    `json:"bytes"`
+	Queued   uint64    `json:"queued"`
+	Limits   *Quota    `json:"limits,omitempty"`
+}
+
+// QuotaStatus ...
+type QuotaStatus struct {
+	Client *QuotaUsage `json:"client"`
+	Tenant *QuotaUsage `json:"tenant"`
+}
@@ -0,0 +1,7 @@
+package api
+
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
@@ -5,17 +5,38 @@ import (
 	"time"
 )
 
+// RedactedValue is shown in place of secrets supplied with a request.
+const RedactedValue = "[redacted]"
+
 type Request struct {
-	ID                   string    `json:"id"`
-	URL                  string    `json:"url"`
-	ExpectedChecksum     string    `json:"expected_checksum,omitempty"`
-	ExpectedChecksumType string    `json:"expected_checksum_type,omitempty"`
-	TimeRequested        time.Time `json:"time_requested"`
-	Callback             string    `json:"callback,omitempty"`
-	DownloadID           string    `json:"download_id,omitempty"`
-	Errors               []*Error  `json:"errors,omitempty"`
-	Metadata             *Metadata `json:"metadata,omitempty"`
-	Links                []Link    `json:"links"`
+	ID                   string            `json:"id"`
+	URL                  string            `json:"url"`
+	ExpectedChecksum     string            `json:"expected_checksum,omitempty"`
+	ExpectedChecksumType string            `json:"expected_checksum_type,omitempty"`
+	ChecksumURL          string            `json:"checksum_url,omitempty"`
+	ExpectedChecksums    []Checksum        `json:"expected_checksums,omitempty"`
+	TimeRequested        time.Time         `json:"time_requested"`
+	Callback             string            `json:"callback,omitempty"`
+	DownloadID           string            `json:"download_id,omitempty"`
+	Errors               []*Error          `json:"errors,omitempty"`
+	Metadata             *Metadata         `json:"metadata,omitempty"`
+	ResolvedURL          string            `json:"resolved_url,omitempty"`
+	RedirectChain        []Redirect        `json:"redirect_chain,omitempty"`
+	Headers              map[string]string `json:"headers,omitempty"`
+	Secrets              string            `json:"secrets,omitempty"`
+	PreviousRequestID    string            `json:"previous_request_id,omitempty"`
+	NextRequestID        string            `json:"next_request_id,omitempty"`
+	Priority             int               `json:"priority"`
+	Submitter            string            `json:"submitter,omitempty"`
+	Owner                string            `json:"owner,omitempty"`
+	Tenant               string            `json:"tenant,omitempty"`
+	State                string            `json:"state,omitempty"`
+	QueuePosition        int               `json:"queue_position,omitempty"`
+	QueueDepth           int               `json:"queue_depth,omitempty"`
+	NotBefore            time.Time         `json:"not_before,omitempty"`
+	Deadline             time.Time         `json:"deadline,omitempty"`
+	Labels               map[string]string `json:"labels,omitempty"`
+	Links                []Link            `json:"links"`
 }
 
 func (r *Request) ResolveLinks(linkResolver *LinkResolver, req *http.Request) {
@@ -23,6 +44,23 @@ func (r *Request) ResolveLinks(linkResolver *LinkResolver, req *http.Request) {
 	r.Links = append(r.Links,
 		Link{Relation: "self", Value: r.ID,
 			ValueID: "id", RouteName: "request"})
+	r.Links = append(r.Links,
+		Link{Relation: "refresh", Value: r.ID,
+			ValueID: "id", RouteName: "request-refresh"})
+	r.Links = append(r.Links,
+		Link{Relation: "history", Value: r.ID,
+			ValueID: "id", RouteName: "request-history"})
+
+	if r.PreviousRequestID != "" {
+		r.Links = append(r.Links,
+			Link{Relation: "previous-version", Value: r.PreviousRequestID,
+				ValueID: "id", RouteName: "request"})
+	}
+	if r.NextRequestID != "" {
+		r.Links = append(r.Links,
+			Link{Relation: "next-version", Value: r.NextRequestID,
+				ValueID: "id", RouteName: "request"})
+	}
 
 	/*
 		if r.Callback != "" {
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+type Schedule struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Cron          string    `json:"cron,omitempty"`
+	Interval      string    `json:"interval,omitempty"`
+	ChecksumURL   string    `json:"checksum_url,omitempty"`
+	ChecksumType  string    `json:"checksum_type,omitempty"`
+	Callback      string    `json:"callback,omitempty"`
+	TimeCreated   time.Time `json:"time_created"`
+	LastRun       time.Time `json:"last_run,omitempty"`
+	NextRun       time.Time `json:"next_run"`
+	LastRequestID string    `json:"last_request_id,omitempty"`
+	Errors        []*Error  `json:"errors,omitempty"`
+	Links         []Link    `json:"links"`
+}
+
+func (s *Schedule) ResolveLinks(linkResolver *LinkResolver, req *http.Request) {
+	s.Links = append(s.Links,
+		Link{Relation: "self", Value: s.ID,
+			ValueID: "id", RouteName: "schedule"})
+
+	if s.LastRequestID != "" {
+		s.Links = append(s.Links,
+			Link{Relation: "last-request", Value: s.LastRequestID,
+				ValueID: "id", RouteName: "request"})
+	}
+
+	linkResolver.ResolveLinks(req, &s.Links)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patdowney/downloaderd-request/download"
+	dh "github.com/patdowney/downloaderd-request/http"
+)
+
+// EnvPrefix starts the names of environment variables that override the
+// config file. The rest of the name is the upper cased JSON field name.
+const EnvPrefix = "DOWNLOADERD_"
+
+// Duration is a time.Duration written as a string such as "30s" in config
+// files and environment variables.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set ...
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON ...
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON ...
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	return d.Set(s)
+}
+
+// Config holds every setting. Settings are taken from the defaults, then a
+// JSON config file, then DOWNLOADERD_* environment variables, then any
+// flags given on the command line.
+type Config struct {
+	ListenAddress   string   `json:"listen_address"`
+	TLSCertFile     string   `json:"tls_cert_file"`
+	TLSKeyFile      string   `json:"tls_key_file"`
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	StoreBackend      string `json:"store_backend"`
+	RequestDataFile   string `json:"request_data_file"`
+	ScheduleDataFile  string `json:"schedule_data_file"`
+	RethinkDBAddress  string `json:"rethinkdb_address"`
+	RethinkDBDatabase string `json:"rethinkdb_database"`
+	RethinkDBMaxIdle  int    `json:"rethinkdb_max_idle"`
+	RethinkDBMaxOpen  int    `json:"rethinkdb_max_open"`
+
+	DownloadServiceURL   string   `json:"download_url"`
+	Dispatchers          int      `json:"dispatchers"`
+	DispatchTimeout      Duration `json:"dispatch_timeout"`
+	DispatchRetries      int      `json:"dispatch_retries"`
+	DispatchRetryBackoff Duration `json:"dispatch_retry_backoff"`
+	ScheduleInterval     Duration `json:"schedule_interval"`
+
+	AccessLogWriter io.Writer `json:"-"`
+	ErrorLogWriter  io.Writer `json:"-"`
+	LogLevel        string    `json:"log_level"`
+	TraceExportFile string    `json:"trace_export_file"`
+
+	SecretKeyFile string `json:"secret_key_file"`
+
+	ProbeTimeout            Duration `json:"probe_timeout"`
+	MaxRedirects            int      `json:"max_redirects"`
+	AllowCrossHostRedirects bool     `json:"cross_host_redirects"`
+	AllowRedirectDowngrade  bool     `json:"downgrade_redirects"`
+
+	AllowedCIDRs string `json:"allow_cidrs"`
+	DeniedCIDRs  string `json:"deny_cidrs"`
+
+	PolicyFile string `json:"policy_file"`
+
+	MaxProbesPerHost        int      `json:"host_probes"`
+	MaxDispatchCallsPerHost int      `json:"host_dispatch_calls"`
+	HostDelay               Duration `json:"host_delay"`
+	MaxHostWait             Duration `json:"host_wait"`
+
+	APIKeyFile  string `json:"api_key_file"`
+	JWKSFile    string `json:"jwks_file"`
+	JWTIssuer   string `json:"jwt_issuer"`
+	JWTAudience string `json:"jwt_audience"`
+
+	TenantsFile string `json:"tenants_file"`
+
+	QuotaRequestsPerDay int    `json:"quota_requests"`
+	QuotaQueued         int    `json:"quota_queued"`
+	QuotaBytesPerDay    uint64 `json:"quota_bytes"`
+
+	MaxBacklog    int      `json:"max_backlog"`
+	HealthTimeout Duration `json:"health_timeout"`
+
+	ReadRateLimit    string `json:"read_limit"`
+	WriteRateLimit   string `json:"write_limit"`
+	RouteRateLimits  string `json:"route_limits"`
+	AddressRateLimit string `json:"address_limit"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		ListenAddress:   "localhost:8090",
+		ReadTimeout:     Duration(30 * time.Second),
+		IdleTimeout:     Duration(2 * time.Minute),
+		ShutdownTimeout: Duration(30 * time.Second),
+
+		StoreBackend:      "local",
+		RequestDataFile:   "requests.json",
+		ScheduleDataFile:  "schedules.json",
+		RethinkDBAddress:  "localhost:28015",
+		RethinkDBDatabase: "Downloaderd",
+		RethinkDBMaxIdle:  10,
+		RethinkDBMaxOpen:  20,
+
+		DownloadServiceURL:   "http://localhost:8080/download/",
+		Dispatchers:          4,
+		DispatchTimeout:      Duration(30 * time.Second),
+		DispatchRetries:      2,
+		DispatchRetryBackoff: Duration(time.Second),
+		ScheduleInterval:     Duration(30 * time.Second),
+
+		AccessLogWriter: os.Stdout,
+		ErrorLogWriter:  os.Stderr,
+		LogLevel:        "info",
+
+		ProbeTimeout:            Duration(30 * time.Second),
+		MaxRedirects:            10,
+		AllowCrossHostRedirects: true,
+
+		MaxProbesPerHost:        2,
+		MaxDispatchCallsPerHost: 4,
+		MaxHostWait:             Duration(30 * time.Second),
+
+		MaxBacklog:    1000,
+		HealthTimeout: Duration(2 * time.Second),
+
+		ReadRateLimit:    "20:40",
+		WriteRateLimit:   "2:10",
+		AddressRateLimit: "50:100"}
+}
+
+// defineFlags binds flags to c's fields, using their current values as the
+// defaults.
+func defineFlags(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.ListenAddress, "http", c.ListenAddress, "address to listen on")
+	fs.StringVar(&c.TLSCertFile, "tlscert", c.TLSCertFile, "TLS certificate file, serves plain HTTP if empty")
+	fs.StringVar(&c.TLSKeyFile, "tlskey", c.TLSKeyFile, "TLS private key file")
+	fs.Var(&c.ReadTimeout, "readtimeout", "time allowed to read a request, 0 for no limit")
+	fs.Var(&c.WriteTimeout, "writetimeout", "time allowed to write a response, 0 for no limit")
+	fs.Var(&c.IdleTimeout, "idletimeout", "time idle keep-alive connections are kept open")
+	fs.Var(&c.ShutdownTimeout, "shutdowntimeout", "time allowed to finish in-flight work on SIGTERM or SIGINT")
+
+	fs.StringVar(&c.StoreBackend, "store", c.StoreBackend, "request store backend: local or rethinkdb")
+	fs.StringVar(&c.RequestDataFile, "requestdata", c.RequestDataFile, "request database file")
+	fs.StringVar(&c.ScheduleDataFile, "scheduledata", c.ScheduleDataFile, "schedule database file")
+	fs.StringVar(&c.RethinkDBAddress, "rethinkdb", c.RethinkDBAddress, "address to connect to")
+	fs.StringVar(&c.RethinkDBDatabase, "rethinkdbname", c.RethinkDBDatabase, "rethinkdb database name")
+	fs.IntVar(&c.RethinkDBMaxIdle, "rethinkdbmaxidle", c.RethinkDBMaxIdle, "idle rethinkdb connections kept open")
+	fs.IntVar(&c.RethinkDBMaxOpen, "rethinkdbmaxopen", c.RethinkDBMaxOpen, "maximum open rethinkdb connections")
+
+	fs.StringVar(&c.DownloadServiceURL, "downloadurl", c.DownloadServiceURL, "download agent service")
+	fs.IntVar(&c.Dispatchers, "dispatchers", c.Dispatchers, "number of requests dispatched to the download agent concurrently")
+	fs.Var(&c.DispatchTimeout, "dispatchtimeout", "time allowed for each call to the download agent")
+	fs.IntVar(&c.DispatchRetries, "dispatchretries", c.DispatchRetries, "times a call to the download agent is retried if it fails to connect or is answered 429 or 503")
+	fs.Var(&c.DispatchRetryBackoff, "dispatchbackoff", "delay before the first retry, doubled for each one after")
+	fs.Var(&c.ScheduleInterval, "scheduleinterval", "how often schedules are checked")
+
+	fs.StringVar(&c.LogLevel, "loglevel", c.LogLevel, "minimum level logged: debug, info, warn or error")
+	fs.StringVar(&c.TraceExportFile, "traceexport", c.TraceExportFile, "file to append OTLP JSON spans to, - for stdout, none if empty")
+
+	fs.StringVar(&c.SecretKeyFile, "secretkey", c.SecretKeyFile, "file containing a hex encoded AES key for request secrets")
+
+	fs.Var(&c.ProbeTimeout, "probetimeout", "time allowed for each metadata probe")
+	fs.IntVar(&c.MaxRedirects, "maxredirects", c.MaxRedirects, "maximum redirects followed when probing metadata")
+	fs.BoolVar(&c.AllowCrossHostRedirects, "crosshostredirects", c.AllowCrossHostRedirects, "follow redirects to a different host")
+	fs.BoolVar(&c.AllowRedirectDowngrade, "downgraderedirects", c.AllowRedirectDowngrade, "follow redirects from https to http")
+	fs.StringVar(&c.AllowedCIDRs, "allowcidrs", c.AllowedCIDRs, "comma separated networks requests may reach despite the deny list")
+	fs.StringVar(&c.DeniedCIDRs, "denycidrs", c.DeniedCIDRs, "comma separated networks to deny in addition to the defaults")
+	fs.StringVar(&c.PolicyFile, "policy", c.PolicyFile, "JSON file of host and url policies, reloaded on change")
+
+	fs.IntVar(&c.MaxProbesPerHost, "hostprobes", c.MaxProbesPerHost, "maximum concurrent metadata probes per host, 0 for no limit")
+	fs.IntVar(&c.MaxDispatchCallsPerHost, "hostdispatchcalls", c.MaxDispatchCallsPerHost, "maximum concurrent calls to the download agent for each origin host, 0 for no limit; downloads the agent is running are not counted")
+	fs.Var(&c.HostDelay, "hostdelay", "minimum delay between requests to the same host")
+	fs.Var(&c.MaxHostWait, "hostwait", "longest a probe waits for its host to be free")
+
+	fs.StringVar(&c.APIKeyFile, "apikeys", c.APIKeyFile, "JSON file of API keys allowed to use the request api")
+	fs.StringVar(&c.JWKSFile, "jwks", c.JWKSFile, "JWKS file of keys trusted to sign bearer tokens")
+	fs.StringVar(&c.JWTIssuer, "jwtissuer", c.JWTIssuer, "required issuer of bearer tokens")
+	fs.StringVar(&c.JWTAudience, "jwtaudience", c.JWTAudience, "required audience of bearer tokens")
+	fs.StringVar(&c.TenantsFile, "tenants", c.TenantsFile, "JSON file of per-tenant callback secrets and policies")
+
+	fs.IntVar(&c.QuotaRequestsPerDay, "quotarequests", c.QuotaRequestsPerDay, "requests each client may make per day, 0 for no limit")
+	fs.IntVar(&c.QuotaQueued, "quotaqueued", c.QuotaQueued, "requests each client may have waiting for dispatch, 0 for no limit; downloads already running are not counted")
+	fs.Uint64Var(&c.QuotaBytesPerDay, "quotabytes", c.QuotaBytesPerDay, "bytes each client may request per day, 0 for no limit")
+
+	fs.IntVar(&c.MaxBacklog, "maxbacklog", c.MaxBacklog, "requests ready for dispatch above which the instance reports itself not ready, 0 for no limit")
+	fs.Var(&c.HealthTimeout, "healthtimeout", "time each readiness check may take")
+
+	fs.StringVar(&c.ReadRateLimit, "readlimit", c.ReadRateLimit, "requests per second:burst each client may read, 0 for no limit")
+	fs.StringVar(&c.WriteRateLimit, "writelimit", c.WriteRateLimit, "requests per second:burst each client may write, 0 for no limit")
+	fs.StringVar(&c.AddressRateLimit, "addresslimit", c.AddressRateLimit, "requests per second:burst each remote address may make before authentication, 0 for no limit")
+	fs.StringVar(&c.RouteRateLimits, "routelimits", c.RouteRateLimits, "comma separated route=rate:burst limits, e.g. request-create=1:5")
+}
+
+// LoadConfigFile overrides c with the settings in a JSON config file.
+// Unknown settings are an error, so typos aren't silently ignored. Only JSON
+// is supported; YAML and TOML config files are out of scope.
+func LoadConfigFile(c *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(c)
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// EnvName returns the environment variable that overrides a setting.
+func EnvName(jsonName string) string {
+	return EnvPrefix + strings.ToUpper(jsonName)
+}
+
+// ApplyEnvironment overrides c with DOWNLOADERD_* variables returned by
+// lookup, such as os.LookupEnv.
+func ApplyEnvironment(c *Config, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var errs []error
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		value, ok := lookup(EnvName(name))
+		if !ok {
+			continue
+		}
+
+		err := setField(v.Field(i), value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", EnvName(name), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setField(field reflect.Value, value string) error {
+	if setter, ok := field.Addr().Interface().(flag.Value); ok {
+		return setter.Set(value)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer '%s'", value)
+		}
+		field.SetInt(int64(i))
+	case reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer '%s'", value)
+		}
+		field.SetUint(u)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean '%s'", value)
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate returns every problem with the settings at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.ListenAddress != "", "listen_address must be set")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+
+	check(c.StoreBackend == "local" || c.StoreBackend == "rethinkdb", "store_backend must be local or rethinkdb, not '%s'", c.StoreBackend)
+	if c.StoreBackend == "local" {
+		check(c.RequestDataFile != "", "request_data_file must be set for the local store")
+	}
+	if c.StoreBackend == "rethinkdb" {
+		check(c.RethinkDBAddress != "", "rethinkdb_address must be set for the rethinkdb store")
+		check(c.RethinkDBDatabase != "", "rethinkdb_database must be set for the rethinkdb store")
+		check(c.RethinkDBMaxOpen > 0, "rethinkdb_max_open must be positive")
+		check(c.RethinkDBMaxIdle >= 0 && c.RethinkDBMaxIdle <= c.RethinkDBMaxOpen, "rethinkdb_max_idle must be between 0 and rethinkdb_max_open")
+	}
+	check(c.ScheduleDataFile != "", "schedule_data_file must be set")
+
+	u, err := url.Parse(c.DownloadServiceURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "download_url must be an absolute http or https url, not '%s'", c.DownloadServiceURL)
+	check(c.Dispatchers > 0, "dispatchers must be positive")
+	check(c.DispatchRetries >= 0, "dispatch_retries must not be negative")
+	check(c.ScheduleInterval > 0, "schedule_interval must be positive")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log_level must be debug, info, warn or error, not '%s'", c.LogLevel)
+
+	check(c.MaxRedirects >= 0, "max_redirects must not be negative")
+	check(c.MaxProbesPerHost >= 0, "host_probes must not be negative")
+	check(c.MaxDispatchCallsPerHost >= 0, "host_dispatch_calls must not be negative")
+	check(c.QuotaRequestsPerDay >= 0, "quota_requests must not be negative")
+	check(c.QuotaQueued >= 0, "quota_queued must not be negative")
+	check(c.MaxBacklog >= 0, "max_backlog must not be negative")
+
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"dispatch_timeout", c.DispatchTimeout},
+		{"dispatch_retry_backoff", c.DispatchRetryBackoff},
+		{"probe_timeout", c.ProbeTimeout},
+		{"host_delay", c.HostDelay},
+		{"host_wait", c.MaxHostWait},
+		{"health_timeout", c.HealthTimeout}}
+	for _, d := range durations {
+		check(d.value >= 0, "%s must not be negative", d.name)
+	}
+
+	_, err = download.ParseCIDRs(strings.Split(c.AllowedCIDRs, ","))
+	check(err == nil, "allow_cidrs: %v", err)
+	_, err = download.ParseCIDRs(strings.Split(c.DeniedCIDRs, ","))
+	check(err == nil, "deny_cidrs: %v", err)
+
+	_, err = dh.ParseRateLimit(c.ReadRateLimit)
+	check(err == nil, "read_limit: %v", err)
+	_, err = dh.ParseRateLimit(c.WriteRateLimit)
+	check(err == nil, "write_limit: %v", err)
+	_, err = dh.ParseRateLimit(c.AddressRateLimit)
+	check(err == nil, "address_limit: %v", err)
+	_, err = dh.ParseRouteRateLimits(c.RouteRateLimits)
+	check(err == nil, "route_limits: %v", err)
+
+	files := []struct {
+		name string
+		path string
+	}{
+		{"tls_cert_file", c.TLSCertFile},
+		{"tls_key_file", c.TLSKeyFile},
+		{"secret_key_file", c.SecretKeyFile},
+		{"policy_file", c.PolicyFile},
+		{"api_key_file", c.APIKeyFile},
+		{"jwks_file", c.JWKSFile},
+		{"tenants_file", c.TenantsFile}}
+	for _, f := range files {
+		if f.path != "" {
+			_, err := os.Stat(f.path)
+			check(err == nil, "%s: %v", f.name, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the settings as a JSON config file.
+func (c *Config) Print(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
+// ParseArgs builds the config from the defaults, the config file named by
+// -config or DOWNLOADERD_CONFIG, the environment and the command line.
+func ParseArgs(args []string) (*Config, bool, error) {
+	c := DefaultConfig()
+
+	fs := flag.NewFlagSet("downloaderd-request", flag.ExitOnError)
+	configFile := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "JSON config file; YAML and TOML are not supported")
+	printConfig := fs.Bool("print-config", false, "print the effective config as JSON and exit")
+	defineFlags(fs, c)
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// flags given on the command line take precedence over the file and
+	// environment, so remember them and apply them again afterwards
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	*c = *DefaultConfig()
+	if *configFile != "" {
+		err = LoadConfigFile(c, *configFile)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	err = ApplyEnvironment(c, os.LookupEnv)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for name, value := range given {
+		err = fs.Set(name, value)
+		if err != nil {
+			return nil, false, fmt.Errorf("-%s: %v", name, err)
+		}
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, false, err
+	}
+	return c, *printConfig, nil
+}
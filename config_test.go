@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		check    func(*Config) bool
+		err      string
+	}{
+		{"settings", `{"dispatchers": 8, "host_wait": "5s", "cross_host_redirects": false}`,
+			func(c *Config) bool {
+				return c.Dispatchers == 8 && c.MaxHostWait == Duration(5*time.Second) && !c.AllowCrossHostRedirects
+			}, ""},
+		{"defaults kept", `{"log_level": "debug"}`,
+			func(c *Config) bool { return c.LogLevel == "debug" && c.Dispatchers == 4 }, ""},
+		{"unknown setting", `{"dispatcher": 8}`, nil, "unknown field"},
+		{"bad duration", `{"host_wait": "soon"}`, nil, "invalid duration"},
+		{"yaml", "dispatchers: 8\n", nil, "invalid character"},
+	}
+
+	for _, tc := range cases {
+		c := DefaultConfig()
+		err := LoadConfigFile(c, writeConfigFile(t, tc.contents))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing '%s', got %v", tc.name, tc.err, err)
+			}
+		} else if err != nil || !tc.check(c) {
+			t.Errorf("%s: unexpected config %+v %v", tc.name, c, err)
+		}
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	cases := []struct {
+		name  string
+		env   map[string]string
+		check func(*Config) bool
+		err   string
+	}{
+		{"string", map[string]string{"DOWNLOADERD_STORE_BACKEND": "rethinkdb"},
+			func(c *Config) bool { return c.StoreBackend == "rethinkdb" }, ""},
+		{"int", map[string]string{"DOWNLOADERD_DISPATCHERS": "8"},
+			func(c *Config) bool { return c.Dispatchers == 8 }, ""},
+		{"uint64", map[string]string{"DOWNLOADERD_QUOTA_BYTES": "1024"},
+			func(c *Config) bool { return c.QuotaBytesPerDay == 1024 }, ""},
+		{"bool", map[string]string{"DOWNLOADERD_CROSS_HOST_REDIRECTS": "false"},
+			func(c *Config) bool { return !c.AllowCrossHostRedirects }, ""},
+		{"duration", map[string]string{"DOWNLOADERD_HOST_WAIT": "5s"},
+			func(c *Config) bool { return c.MaxHostWait == Duration(5*time.Second) }, ""},
+		{"bad int", map[string]string{"DOWNLOADERD_DISPATCHERS": "many"}, nil, "DOWNLOADERD_DISPATCHERS: invalid integer"},
+		{"bad bool", map[string]string{"DOWNLOADERD_CROSS_HOST_REDIRECTS": "maybe"}, nil, "DOWNLOADERD_CROSS_HOST_REDIRECTS: invalid boolean"},
+	}
+
+	for _, tc := range cases {
+		c := DefaultConfig()
+		err := ApplyEnvironment(c, func(name string) (string, bool) {
+			value, ok := tc.env[name]
+			return value, ok
+		})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing '%s', got %v", tc.name, tc.err, err)
+			}
+		} else if err != nil || !tc.check(c) {
+			t.Errorf("%s: unexpected config %+v %v", tc.name, c, err)
+		}
+	}
+}
+
+func TestParseArgsPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `{"dispatchers": 2, "dispatch_retries": 3, "max_redirects": 4}`)
+	t.Setenv("DOWNLOADERD_DISPATCH_RETRIES", "5")
+	t.Setenv("DOWNLOADERD_MAX_REDIRECTS", "6")
+
+	c, _, err := ParseArgs([]string{"-config", path, "-maxredirects", "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		value    int
+		expected int
+	}{
+		{"file over default", c.Dispatchers, 2},
+		{"environment over file", c.DispatchRetries, 5},
+		{"flag over environment", c.MaxRedirects, 7},
+	} {
+		if tc.value != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, tc.value)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		change func(*Config)
+		err    string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"store backend", func(c *Config) { c.StoreBackend = "s3" }, "store_backend must be local or rethinkdb"},
+		{"tls pair", func(c *Config) { c.TLSCertFile = "cert.pem" }, "tls_cert_file and tls_key_file must be set together"},
+		{"download url", func(c *Config) { c.DownloadServiceURL = "localhost:8080" }, "download_url must be an absolute"},
+		{"dispatchers", func(c *Config) { c.Dispatchers = 0 }, "dispatchers must be positive"},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "log_level must be"},
+		{"negative duration", func(c *Config) { c.HostDelay = Duration(-time.Second) }, "host_delay must not be negative"},
+		{"rate limit", func(c *Config) { c.ReadRateLimit = "fast" }, "read_limit"},
+		{"missing file", func(c *Config) { c.PolicyFile = "missing.json" }, "policy_file"},
+	}
+
+	for _, tc := range cases {
+		c := DefaultConfig()
+		tc.change(c)
+		err := c.Validate()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", tc.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing '%s', got %v", tc.name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package download
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// DefaultDeniedCIDRs are the loopback, link-local, private, multicast and
+// reserved ranges that submitted URLs may not reach unless allowed.
+var DefaultDeniedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// BlockedAddressError ...
+type BlockedAddressError struct {
+	Host string
+	IP   net.IP
+}
+
+func (e *BlockedAddressError) Error() string {
+	if e.Host != "" && e.Host != e.IP.String() {
+		return fmt.Sprintf("host %s resolves to blocked address %s", e.Host, e.IP)
+	}
+	return fmt.Sprintf("blocked address %s", e.IP)
+}
+
+// AddressGuard refuses connections to denied networks. Allowed networks
+// take precedence over denied ones.
+type AddressGuard struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// NewAddressGuard ...
+func NewAddressGuard() *AddressGuard {
+	deny, _ := ParseCIDRs(DefaultDeniedCIDRs)
+	return &AddressGuard{
+		Allow: make([]*net.IPNet, 0),
+		Deny:  deny}
+}
+
+// ParseCIDRs ...
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IsAllowed ...
+func (g *AddressGuard) IsAllowed(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range g.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range g.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost resolves host and returns a BlockedAddressError if any of its
+// addresses are denied.
+func (g *AddressGuard) CheckHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !g.IsAllowed(ip) {
+			return &BlockedAddressError{Host: host, IP: ip}
+		}
+	}
+	return nil
+}
+
+// Control is used as a net.Dialer Control function so the check happens
+// on the address actually dialled, after DNS resolution.
+func (g *AddressGuard) Control(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("unresolved address %s", address)
+	}
+	if !g.IsAllowed(ip) {
+		return &BlockedAddressError{IP: ip}
+	}
+	return nil
+}
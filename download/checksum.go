@@ -0,0 +1,191 @@
+package download
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// Checksum ...
+type Checksum struct {
+	Type  string
+	Value string
+}
+
+// ChecksumAlgorithm ...
+type ChecksumAlgorithm struct {
+	Name string
+	Size int
+	New  func() hash.Hash
+}
+
+func mustHash(h hash.Hash, err error) hash.Hash {
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+var checksumAlgorithms = []*ChecksumAlgorithm{
+	{"md5", md5.Size, md5.New},
+	{"sha1", sha1.Size, sha1.New},
+	{"sha224", sha256.Size224, sha256.New224},
+	{"sha256", sha256.Size, sha256.New},
+	{"sha384", sha512.Size384, sha512.New384},
+	{"sha512", sha512.Size, sha512.New},
+	{"sha512-256", sha512.Size256, sha512.New512_256},
+	{"sha3-224", 28, sha3.New224},
+	{"sha3-256", 32, sha3.New256},
+	{"sha3-384", 48, sha3.New384},
+	{"sha3-512", 64, sha3.New512},
+	{"blake2b-256", blake2b.Size256, func() hash.Hash { return mustHash(blake2b.New256(nil)) }},
+	{"blake2b-384", blake2b.Size384, func() hash.Hash { return mustHash(blake2b.New384(nil)) }},
+	{"blake2b-512", blake2b.Size, func() hash.Hash { return mustHash(blake2b.New512(nil)) }},
+	{"blake2s-256", blake2s.Size, func() hash.Hash { return mustHash(blake2s.New256(nil)) }},
+	{"crc32c", crc32.Size, func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+}
+
+// checksumAliases maps alternative spellings to algorithm names.
+var checksumAliases = map[string]string{
+	"sha-1":   "sha1",
+	"sha-224": "sha224",
+	"sha-256": "sha256",
+	"sha-384": "sha384",
+	"sha-512": "sha512",
+	"blake2b": "blake2b-512",
+	"blake2s": "blake2s-256",
+	"crc-32c": "crc32c",
+}
+
+// NormalizeChecksumType lower cases a checksum type and resolves aliases,
+// so "SHA512/256" and "BLAKE2b" become "sha512-256" and "blake2b-512".
+func NormalizeChecksumType(checksumType string) string {
+	name := strings.ToLower(strings.TrimSpace(checksumType))
+	name = strings.NewReplacer("_", "-", "/", "-").Replace(name)
+	if alias, ok := checksumAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// LookupChecksumAlgorithm ...
+func LookupChecksumAlgorithm(checksumType string) (*ChecksumAlgorithm, error) {
+	name := NormalizeChecksumType(checksumType)
+	for _, a := range checksumAlgorithms {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported checksum type '%s'", checksumType)
+}
+
+// checksumSizes maps digest sizes in bytes to the checksum type assumed
+// when none is given. Other algorithms sharing a size must be named.
+var checksumSizes = map[int]string{
+	crc32.Size:     "crc32c",
+	md5.Size:       "md5",
+	sha1.Size:      "sha1",
+	sha256.Size224: "sha224",
+	sha256.Size:    "sha256",
+	sha512.Size384: "sha384",
+	sha512.Size:    "sha512",
+}
+
+// DetectChecksumType returns the checksum type implied by the length of a
+// hex digest, or an empty string if the length is not recognised.
+func DetectChecksumType(checksum string) string {
+	if len(checksum)%2 != 0 {
+		return ""
+	}
+	return checksumSizes[len(checksum)/2]
+}
+
+// decodeDigest decodes a hex digest, or a base64 one if it isn't valid hex.
+// A hex digest is also valid base64, so one of the wrong length must not be
+// decoded again as base64 to find a length that fits.
+func decodeDigest(checksum string) ([]byte, error) {
+	if d, err := hex.DecodeString(checksum); err == nil {
+		return d, nil
+	}
+	if d, err := base64.StdEncoding.DecodeString(checksum); err == nil {
+		return d, nil
+	}
+	if d, err := base64.RawURLEncoding.DecodeString(checksum); err == nil {
+		return d, nil
+	}
+	return nil, fmt.Errorf("checksum '%s' is neither hex nor base64", checksum)
+}
+
+// NormalizeChecksum decodes a hex or base64 checksum and checks its length
+// against the checksum type, detecting the type from the length if it is
+// empty. Types shorter than md5 are never detected, as too many other
+// strings have the same length. It returns the checksum as lower case hex
+// and the normalized type.
+func NormalizeChecksum(checksum string, checksumType string) (string, string, error) {
+	checksum = strings.TrimSpace(checksum)
+
+	digest, err := decodeDigest(checksum)
+	if err != nil {
+		return "", "", err
+	}
+
+	if checksumType == "" {
+		if t, ok := checksumSizes[len(digest)]; ok && len(digest) >= md5.Size {
+			return hex.EncodeToString(digest), t, nil
+		}
+		return "", "", fmt.Errorf("unable to detect checksum type of '%s' from its length", checksum)
+	}
+
+	algorithm, err := LookupChecksumAlgorithm(checksumType)
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(digest) != algorithm.Size {
+		return "", "", fmt.Errorf("%s checksum must be %d bytes, got %d",
+			algorithm.Name, algorithm.Size, len(digest))
+	}
+	return hex.EncodeToString(digest), algorithm.Name, nil
+}
+
+// ParseIntegrity parses a Subresource Integrity string such as
+// "sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"
+// into hex encoded checksums. Multiple space separated values are allowed.
+func ParseIntegrity(integrity string) ([]Checksum, error) {
+	checksums := make([]Checksum, 0)
+	for _, value := range strings.Fields(integrity) {
+		// options after '?' are reserved by the SRI spec and ignored
+		value = strings.SplitN(value, "?", 2)[0]
+
+		parts := strings.SplitN(value, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid integrity value '%s'", value)
+		}
+
+		algorithm, err := LookupChecksumAlgorithm(parts[0])
+		if err != nil {
+			return nil, err
+		}
+
+		digest, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid integrity value '%s': %v", value, err)
+		}
+
+		checksums = append(checksums, Checksum{
+			Type:  algorithm.Name,
+			Value: hex.EncodeToString(digest)})
+	}
+	return checksums, nil
+}
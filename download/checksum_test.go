@@ -0,0 +1,108 @@
+package download
+
+import (
+	"io"
+	"testing"
+)
+
+func TestLookupChecksumAlgorithm(t *testing.T) {
+	for _, name := range []string{"SHA512/256", "sha3-384", "BLAKE2b", "blake2s-256", "crc32c", "sha224"} {
+		_, err := LookupChecksumAlgorithm(name)
+		if err != nil {
+			t.Errorf("LookupChecksumAlgorithm('%s'): unexpected error %v", name, err)
+		}
+	}
+
+	_, err := LookupChecksumAlgorithm("abc")
+	if err == nil {
+		t.Errorf("LookupChecksumAlgorithm('%s'): expected error", "abc")
+	}
+}
+
+func TestParseIntegrity(t *testing.T) {
+	// sha256 of the empty string
+	checksums, err := ParseIntegrity("sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Checksum{Type: "sha256", Value: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+	if len(checksums) != 1 || checksums[0] != expected {
+		t.Errorf("ParseIntegrity = %v want %v", checksums, expected)
+	}
+}
+
+func TestVerifyMultipleChecksums(t *testing.T) {
+	d := &Download{Checksums: []Checksum{
+		{Type: "md5", Value: "d41d8cd98f00b204e9800998ecf8427e"},
+		{Type: "sha256", Value: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}}
+
+	hashes, err := d.Hashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hashes {
+		io.WriteString(h, "")
+	}
+
+	err = d.VerifyChecksums(hashes)
+	if err != nil {
+		t.Errorf("VerifyChecksums: unexpected error %v", err)
+	}
+
+	d.Checksums[1].Value = "0000000000000000000000000000000000000000000000000000000000000000"
+	err = d.VerifyChecksums(hashes)
+	if err == nil {
+		t.Errorf("VerifyChecksums: expected mismatch error")
+	}
+}
+
+func TestNormalizeChecksum(t *testing.T) {
+	emptySHA256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	cases := []struct {
+		checksum     string
+		checksumType string
+		expectedType string
+	}{
+		{"E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", "sha256", "sha256"},
+		{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "SHA256", "sha256"},
+		{emptySHA256, "", "sha256"},
+	}
+
+	for _, c := range cases {
+		checksum, checksumType, err := NormalizeChecksum(c.checksum, c.checksumType)
+		if err != nil {
+			t.Errorf("NormalizeChecksum('%s', '%s'): unexpected error %v", c.checksum, c.checksumType, err)
+			continue
+		}
+		if checksum != emptySHA256 || checksumType != c.expectedType {
+			t.Errorf("NormalizeChecksum('%s', '%s') = %s, %s want %s, %s",
+				c.checksum, c.checksumType, checksum, checksumType, emptySHA256, c.expectedType)
+		}
+	}
+}
+
+func TestNormalizeChecksumLengthMismatch(t *testing.T) {
+	// a sha1 digest labelled as sha256
+	_, _, err := NormalizeChecksum("da39a3ee5e6b4b0d3255bfef95601890afd80709", "sha256")
+	if err == nil {
+		t.Errorf("NormalizeChecksum: expected length mismatch error")
+	}
+
+	// a sha256 digest labelled as sha384 must not be read as base64
+	_, _, err = NormalizeChecksum("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "sha384")
+	if err == nil {
+		t.Errorf("NormalizeChecksum: expected length mismatch error for hex read as base64")
+	}
+
+	_, _, err = NormalizeChecksum("deadbeef", "")
+	if err == nil {
+		t.Errorf("NormalizeChecksum: expected crc32c not to be detected")
+	}
+
+	_, _, err = NormalizeChecksum("not-a-checksum!", "sha256")
+	if err == nil {
+		t.Errorf("NormalizeChecksum: expected encoding error")
+	}
+}
@@ -0,0 +1,75 @@
+package download
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// SHA256 (file.iso) = abcdef...
+	bsdChecksumLine = regexp.MustCompile(`^([A-Za-z0-9_/-]+) ?\((.+)\) ?= ?([0-9A-Fa-f]+)$`)
+	// abcdef...  file.iso or abcdef... *file.iso
+	gnuChecksumLine = regexp.MustCompile(`^\\?([0-9A-Fa-f]+) [ *]?(.+)$`)
+	// abcdef...
+	singleChecksumLine = regexp.MustCompile(`^([0-9A-Fa-f]+)$`)
+)
+
+// checksumTypeFromName guesses the checksum type from a checksum file name
+// such as SHA256SUMS or file.iso.sha1.
+func checksumTypeFromName(name string) string {
+	name = strings.ToLower(path.Base(name))
+	for _, t := range []string{"sha512", "sha256", "sha1", "md5"} {
+		if strings.Contains(name, t) {
+			return t
+		}
+	}
+	return ""
+}
+
+// ParseChecksumFile finds the checksum for filename in a GNU coreutils,
+// BSD-style or single hash checksum file. The checksum type is taken from
+// BSD tags, then the checksum file name, then the digest length.
+func ParseChecksumFile(contents string, checksumFileName string, filename string) (string, string, error) {
+	single := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+			if path.Base(m[2]) == filename {
+				return strings.ToLower(m[3]), NormalizeChecksumType(m[1]), nil
+			}
+		} else if m := gnuChecksumLine.FindStringSubmatch(line); m != nil {
+			if path.Base(m[2]) == filename {
+				return strings.ToLower(m[1]), typeForChecksum(checksumFileName, m[1]), nil
+			}
+		} else if m := singleChecksumLine.FindStringSubmatch(line); m != nil && single == "" {
+			single = m[1]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	if single != "" {
+		return strings.ToLower(single), typeForChecksum(checksumFileName, single), nil
+	}
+
+	return "", "", fmt.Errorf("no checksum found for %s in %s", filename, checksumFileName)
+}
+
+func typeForChecksum(checksumFileName string, checksum string) string {
+	checksumType := checksumTypeFromName(checksumFileName)
+	if checksumType == "" {
+		checksumType = DetectChecksumType(checksum)
+	}
+	return checksumType
+}
@@ -0,0 +1,83 @@
+package download
+
+import (
+	"testing"
+)
+
+func TestParseGNUChecksumFile(t *testing.T) {
+	contents := `d41d8cd98f00b204e9800998ecf8427e  other.iso
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 *file.iso
+`
+	checksum, checksumType, err := ParseChecksumFile(contents, "/releases/SHA256SUMS", "file.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if checksum != expected {
+		t.Errorf("checksum: expected %s, got %s", expected, checksum)
+	}
+	if checksumType != "sha256" {
+		t.Errorf("checksumType: expected %s, got %s", "sha256", checksumType)
+	}
+}
+
+func TestParseBSDChecksumFile(t *testing.T) {
+	contents := `MD5 (other.iso) = d41d8cd98f00b204e9800998ecf8427e
+SHA1 (file.iso) = DA39A3EE5E6B4B0D3255BFEF95601890AFD80709
+`
+	checksum, checksumType, err := ParseChecksumFile(contents, "/releases/CHECKSUM", "file.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	if checksum != expected {
+		t.Errorf("checksum: expected %s, got %s", expected, checksum)
+	}
+	if checksumType != "sha1" {
+		t.Errorf("checksumType: expected %s, got %s", "sha1", checksumType)
+	}
+}
+
+func TestParseBSDChecksumTags(t *testing.T) {
+	for tag, expected := range map[string]string{
+		"SHA-256":     "sha256",
+		"SHA3-256":    "sha3-256",
+		"SHA512-256":  "sha512-256",
+		"SHA512/256":  "sha512-256",
+		"BLAKE2b-512": "blake2b-512",
+		"BLAKE2b":     "blake2b-512",
+	} {
+		contents := tag + " (file.iso) = e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n"
+		_, checksumType, err := ParseChecksumFile(contents, "/releases/CHECKSUM", "file.iso")
+		if err != nil || checksumType != expected {
+			t.Errorf("%s: expected %s, got %s %v", tag, expected, checksumType, err)
+		}
+	}
+}
+
+func TestParseSingleChecksumFile(t *testing.T) {
+	contents := "d41d8cd98f00b204e9800998ecf8427e\n"
+
+	checksum, checksumType, err := ParseChecksumFile(contents, "/releases/file.iso.md5", "file.iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checksum != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("checksum: expected %s, got %s", "d41d8cd98f00b204e9800998ecf8427e", checksum)
+	}
+	if checksumType != "md5" {
+		t.Errorf("checksumType: expected %s, got %s", "md5", checksumType)
+	}
+}
+
+func TestParseChecksumFileMissingEntry(t *testing.T) {
+	contents := "d41d8cd98f00b204e9800998ecf8427e  other.iso\n"
+
+	_, _, err := ParseChecksumFile(contents, "/releases/MD5SUMS", "file.iso")
+	if err == nil {
+		t.Errorf("ParseChecksumFile: expected error for missing entry")
+	}
+}
@@ -2,64 +2,163 @@ package download
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/tracing"
 )
 
+// RequestIDHeader carries a request's correlation id to the download agent.
+const RequestIDHeader = "X-Request-ID"
+
+// IdempotencyKeyHeader carries the request's id to the download agent, so
+// that it can recognise a request it has already accepted.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 // Client ...
 type Client interface {
-	ProcessRequest(*Request) (*Download, error)
+	ProcessRequest(context.Context, *Request) (*Download, error)
 }
 
-// HTTPClient ...
+// HTTPClient dispatches requests to the download agent. Only calls the
+// agent can't have acted on are retried: those that fail to connect, and
+// those it answers with 429 or 503. Anything else, such as a timeout after
+// the request was sent, might have started a download and is not retried.
+// Calls are retried up to Retries times, waiting RetryBackoff before the
+// first retry and twice as long before each one after.
 type HTTPClient struct {
-	URL *url.URL
+	URL          *url.URL
+	Client       *http.Client
+	Retries      int
+	RetryBackoff time.Duration
 }
 
-// ProcessRequest ...
-func (c *HTTPClient) ProcessRequest(r *Request) (*Download, error) {
+func (c *HTTPClient) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
 
-	p, _ := json.MarshalIndent(r, "", "  ")
-	log.Printf("p:%v", string(p))
+// ProcessRequest ...
+func (c *HTTPClient) ProcessRequest(ctx context.Context, r *Request) (d *Download, err error) {
+	ctx, span := startSpan(ctx, "HTTPClient.ProcessRequest", tracing.KindClient, r)
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("http.method", "POST")
+	span.SetAttribute("url.full", c.URL.String())
 
 	rr := api.IncomingDownload{
-		RequestID:    r.ID,
-		URL:          r.URL,
-		Checksum:     r.Checksum,
-		ChecksumType: r.ChecksumType,
-		Callback:     r.Callback,
-		ETag:         r.Metadata.ETag,
+		RequestID:      r.ID,
+		URL:            r.URL,
+		Checksum:       r.Checksum,
+		ChecksumType:   r.ChecksumType,
+		Checksums:      ToAPIChecksums(r.ExpectedChecksums()),
+		Callback:       r.Callback,
+		CallbackSecret: r.CallbackSecret,
+		ETag:           r.Metadata.ETag,
+		Deadline:       r.Deadline,
+		Labels:         r.Labels,
+		CorrelationID:  r.CorrelationID,
+		TraceParent:    tracing.Traceparent(ctx),
+		Headers:        make(map[string]string),
 	}
 
-	return c.postRequest(rr)
-}
+	for k, v := range r.OriginHeaders() {
+		rr.Headers[k] = v[0]
+	}
 
-func (c *HTTPClient) postRequest(r api.IncomingDownload) (*Download, error) {
-	p, _ := json.MarshalIndent(r, "", "  ")
-	log.Printf("p:%v", string(p))
+	return c.postRequest(ctx, r, rr)
+}
 
-	jsonBytes, err := json.Marshal(r)
+func (c *HTTPClient) postRequest(ctx context.Context, r *Request, rr api.IncomingDownload) (*Download, error) {
+	jsonBytes, err := json.Marshal(rr)
 	if err != nil {
 		return nil, err
 	}
 
-	byteReader := bytes.NewReader(jsonBytes)
-	res, err := http.Post(c.URL.String(), "application/json", byteReader)
-	defer func() {
-		err := res.Body.Close()
-		log.Printf("%v\n", err)
-	}()
+	backoff := c.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		d, retry, err := c.post(ctx, jsonBytes, rr.RequestID, rr.CorrelationID)
+		if !retry || attempt >= c.Retries {
+			return d, err
+		}
+
+		Logger(r).Warn("dispatch-retry", "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
 
+// post makes one call to the download agent, returning whether it is safe
+// to retry if it fails.
+func (c *HTTPClient) post(ctx context.Context, jsonBytes []byte, requestID string, correlationID string) (*Download, bool, error) {
+	req, err := http.NewRequest("POST", c.URL.String(), bytes.NewReader(jsonBytes))
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, requestID)
+	if correlationID != "" {
+		req.Header.Set(RequestIDHeader, correlationID)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	res, err := c.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, isDialError(err), err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		retry := res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusTooManyRequests
+		return nil, retry, fmt.Errorf("postRequest: status: %v", res.StatusCode)
+	}
+
+	var d api.Download
+	err = json.NewDecoder(res.Body).Decode(&d)
+	if err != nil || d.ID == "" {
+		return nil, false, nil
+	}
+
+	return &Download{ID: d.ID, URL: d.URL}, false, nil
+}
+
+// isDialError returns true if err means the connection couldn't be made,
+// so nothing was sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Ping checks that the download agent responds. Any response other than a
+// server error counts, since the agent has no dedicated health endpoint.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", c.URL.String(), nil)
+	if err != nil {
+		return err
 	}
 
-	return nil, fmt.Errorf("postRequest: status: %v", res.StatusCode)
+	res, err := c.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("download agent responded %d", res.StatusCode)
+	}
+	return nil
 }
 
 // NewHTTPClient ...
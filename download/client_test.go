@@ -0,0 +1,37 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHTTPClientOnlyRetriesCallsNotActedOn(t *testing.T) {
+	for status, expectedCalls := range map[int]int32{
+		http.StatusInternalServerError: 1,
+		http.StatusBadGateway:          1,
+		http.StatusServiceUnavailable:  3,
+		http.StatusTooManyRequests:     3,
+	} {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if req.Header.Get(IdempotencyKeyHeader) != "r" {
+				t.Errorf("expected the request id as idempotency key, got '%s'", req.Header.Get(IdempotencyKeyHeader))
+			}
+			rw.WriteHeader(status)
+		}))
+
+		u, _ := url.Parse(server.URL)
+		c := &HTTPClient{URL: u, Retries: 2}
+		_, err := c.ProcessRequest(context.Background(), &Request{ID: "r", URL: "http://example.com/", Metadata: &Metadata{}})
+		server.Close()
+
+		if err == nil || calls != expectedCalls {
+			t.Errorf("status %d: expected %d calls and an error, got %d calls and %v", status, expectedCalls, calls, err)
+		}
+	}
+}
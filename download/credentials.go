@@ -0,0 +1,52 @@
+package download
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// SensitiveHeaders are custom headers whose values are kept with the
+// request secrets rather than stored in the clear.
+var SensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// IsSensitiveHeader ...
+func IsSensitiveHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, h := range SensitiveHeaders {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials ...
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// AuthorizationHeader ...
+func (c *Credentials) AuthorizationHeader() string {
+	if c.Token != "" {
+		return "Bearer " + c.Token
+	}
+	if c.Username != "" {
+		auth := c.Username + ":" + c.Password
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+	}
+	return ""
+}
+
+// Secrets ...
+type Secrets struct {
+	Headers     map[string]string
+	Credentials *Credentials
+}
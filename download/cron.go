@@ -0,0 +1,147 @@
+package download
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week.
+type CronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type cronField struct {
+	min int
+	max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 are both sunday
+}
+
+// ParseCron ...
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression '%s' must have %d fields", expr, len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		var err error
+		bits[i], err = parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression '%s': %v", expr, err)
+		}
+	}
+
+	// fold sunday as 7 onto 0
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &CronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     bits[4],
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*"}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+
+		low, high := bounds.min, bounds.max
+		if part != "*" {
+			rangeParts := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = strconv.Atoi(rangeParts[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", part)
+			}
+			high = low
+			if len(rangeParts) == 2 {
+				high, err = strconv.Atoi(rangeParts[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid range '%s'", part)
+				}
+			} else if step > 1 {
+				high = bounds.max
+			}
+		}
+
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, fmt.Errorf("'%s' out of range %d-%d", part, bounds.min, bounds.max)
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dow := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+
+	// as in cron, a restricted day of month and day of week match either
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t that matches the schedule, or the
+// zero time if there is none within five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,43 @@
+package download
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2015, time.April, 9, 23, 30, 0, 0, time.UTC) // a thursday
+
+	cases := map[string]time.Time{
+		"* * * * *":      time.Date(2015, time.April, 9, 23, 31, 0, 0, time.UTC),
+		"0 2 * * *":      time.Date(2015, time.April, 10, 2, 0, 0, 0, time.UTC),
+		"*/15 * * * *":   time.Date(2015, time.April, 9, 23, 45, 0, 0, time.UTC),
+		"0 0 1 * *":      time.Date(2015, time.May, 1, 0, 0, 0, 0, time.UTC),
+		"30 4 * * 0":     time.Date(2015, time.April, 12, 4, 30, 0, 0, time.UTC),
+		"30 4 * * 7":     time.Date(2015, time.April, 12, 4, 30, 0, 0, time.UTC),
+		"0 9-17 * * 1-5": time.Date(2015, time.April, 10, 9, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":     time.Date(2015, time.April, 10, 0, 0, 0, 0, time.UTC),
+	}
+
+	for expr, expected := range cases {
+		c, err := ParseCron(expr)
+		if err != nil {
+			t.Errorf("ParseCron('%s'): unexpected error %v", expr, err)
+			continue
+		}
+
+		actual := c.Next(from)
+		if !actual.Equal(expected) {
+			t.Errorf("ParseCron('%s').Next(%v) = %v want %v", expr, from, actual, expected)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+		_, err := ParseCron(expr)
+		if err == nil {
+			t.Errorf("ParseCron('%s'): expected error", expr)
+		}
+	}
+}
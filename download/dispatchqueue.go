@@ -0,0 +1,287 @@
+package download
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/common"
+)
+
+// Request priorities, higher priorities are dispatched first.
+const (
+	MinPriority = 0
+	MaxPriority = 9
+)
+
+// DispatchQueue shares dispatches fairly between submitters, so that one
+// submitter's backlog cannot starve another's whatever priority it asks
+// for. Each submitter's own requests are dispatched by priority, highest
+// first.
+type DispatchQueue struct {
+	sync.Mutex
+	Clock      common.Clock
+	cond       *sync.Cond
+	queues     map[string][]*Request
+	lastServed map[string]uint64
+	served     uint64
+	closed     bool
+}
+
+// NewDispatchQueue ...
+func NewDispatchQueue() *DispatchQueue {
+	q := &DispatchQueue{
+		Clock:      &common.RealClock{},
+		queues:     make(map[string][]*Request),
+		lastServed: make(map[string]uint64)}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// Push ...
+func (q *DispatchQueue) Push(r *Request) {
+	q.Lock()
+	defer q.Unlock()
+
+	queue := q.queues[r.Submitter]
+
+	// keep each submitter's queue in priority order, oldest first
+	i := len(queue)
+	for i > 0 && queue[i-1].Priority < r.Priority {
+		i--
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = r
+
+	q.queues[r.Submitter] = queue
+	q.cond.Signal()
+}
+
+func always(*Request) bool {
+	return true
+}
+
+// next returns the submitter and index of the request that should be
+// dispatched next: of the first ready request in each submitter's queue,
+// the one whose submitter was served least recently. Priority and then age
+// only decide between submitters served equally.
+func next(queues map[string][]*Request, lastServed map[string]uint64, ready func(*Request) bool) (string, int) {
+	submitter := ""
+	index := -1
+	var best *Request
+	for s, queue := range queues {
+		for i, r := range queue {
+			if !ready(r) {
+				continue
+			}
+			if best == nil || lastServed[s] < lastServed[submitter] ||
+				(lastServed[s] == lastServed[submitter] && r.Priority > best.Priority) ||
+				(lastServed[s] == lastServed[submitter] && r.Priority == best.Priority && r.TimeRequested.Before(best.TimeRequested)) {
+				submitter, index, best = s, i, r
+			}
+			break
+		}
+	}
+	return submitter, index
+}
+
+func (q *DispatchQueue) pop(ready func(*Request) bool) *Request {
+	submitter, index := next(q.queues, q.lastServed, ready)
+	if index < 0 {
+		return nil
+	}
+
+	queue := q.queues[submitter]
+	r := queue[index]
+	if len(queue) == 1 {
+		delete(q.queues, submitter)
+	} else {
+		q.queues[submitter] = append(queue[:index:index], queue[index+1:]...)
+	}
+
+	q.served++
+	q.lastServed[submitter] = q.served
+	q.pruneServed()
+	return r
+}
+
+// pruneServed forgets when submitters with nothing queued were last served,
+// if it was before every submitter with something queued. They are
+// dispatched ahead of those submitters either way.
+func (q *DispatchQueue) pruneServed() {
+	oldest := q.served
+	for s := range q.queues {
+		if q.lastServed[s] < oldest {
+			oldest = q.lastServed[s]
+		}
+	}
+	for s, served := range q.lastServed {
+		if _, queued := q.queues[s]; !queued && served < oldest {
+			delete(q.lastServed, s)
+		}
+	}
+}
+
+// Pop returns the next request, or nil if the queue is empty.
+func (q *DispatchQueue) Pop() *Request {
+	q.Lock()
+	defer q.Unlock()
+	return q.pop(always)
+}
+
+// Next blocks until a request is available and returns it. It returns
+// false once the queue has been closed.
+func (q *DispatchQueue) Next() (*Request, bool) {
+	return q.NextReady(nil)
+}
+
+// NextReady blocks until a request is available that has reached its
+// NotBefore time and whose host is ready in limiter, starts it in limiter
+// and returns it. Requests for other hosts are not held up by a busy host,
+// and requests past their deadline are returned straight away so they can
+// be failed. It returns false once the queue has been closed.
+func (q *DispatchQueue) NextReady(limiter *HostLimiter) (*Request, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for {
+		if q.closed {
+			return nil, false
+		}
+
+		now := q.Clock.Now()
+		var wait time.Duration
+		waitFor := func(w time.Duration) {
+			if w > 0 && (wait == 0 || w < wait) {
+				wait = w
+			}
+		}
+
+		ready := func(r *Request) bool {
+			if r.DeadlinePassed(now) {
+				return true
+			}
+			if r.NotBefore.After(now) {
+				waitFor(r.NotBefore.Sub(now))
+				return false
+			}
+			if limiter == nil {
+				return true
+			}
+			ok, w := limiter.Ready(r.Host())
+			waitFor(w)
+			return ok
+		}
+
+		r := q.pop(ready)
+		if r != nil {
+			if limiter != nil {
+				limiter.Start(r.Host())
+			}
+			return r, true
+		}
+
+		// wake up when the first deferred host or scheduled request becomes
+		// ready, as well as on Push and Wake
+		if wait > 0 {
+			timer := time.AfterFunc(wait, q.Wake)
+			q.cond.Wait()
+			timer.Stop()
+		} else {
+			q.cond.Wait()
+		}
+	}
+}
+
+// Wake wakes callers blocked in Next so they can check for ready requests
+// again, for example when a host has been released.
+func (q *DispatchQueue) Wake() {
+	q.Lock()
+	defer q.Unlock()
+	q.cond.Broadcast()
+}
+
+// Close wakes any callers blocked in Next.
+func (q *DispatchQueue) Close() {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Remove removes the request with the given id, returning false if it is
+// not queued.
+func (q *DispatchQueue) Remove(requestID string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for submitter, queue := range q.queues {
+		for i, r := range queue {
+			if r.ID != requestID {
+				continue
+			}
+			if len(queue) == 1 {
+				delete(q.queues, submitter)
+			} else {
+				q.queues[submitter] = append(queue[:i:i], queue[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Len ...
+func (q *DispatchQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	n := 0
+	for _, queue := range q.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// ReadyLen returns the number of requests that may be dispatched at t,
+// leaving out those scheduled for later unless their deadline has passed.
+func (q *DispatchQueue) ReadyLen(t time.Time) int {
+	q.Lock()
+	defer q.Unlock()
+	n := 0
+	for _, queue := range q.queues {
+		for _, r := range queue {
+			if r.DeadlinePassed(t) || !r.NotBefore.After(t) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Position returns the 1-based position the request would be dispatched
+// in if nothing else were queued, or 0 if it is not queued.
+func (q *DispatchQueue) Position(requestID string) int {
+	q.Lock()
+	defer q.Unlock()
+
+	queues := make(map[string][]*Request, len(q.queues))
+	lastServed := make(map[string]uint64, len(q.lastServed))
+	for s, queue := range q.queues {
+		queues[s] = queue
+		lastServed[s] = q.lastServed[s]
+	}
+
+	served := q.served
+	for position := 1; ; position++ {
+		submitter, index := next(queues, lastServed, always)
+		if index < 0 {
+			return 0
+		}
+		queue := queues[submitter]
+		if queue[0].ID == requestID {
+			return position
+		}
+		queues[submitter] = queue[1:]
+		served++
+		lastServed[submitter] = served
+	}
+}
@@ -0,0 +1,98 @@
+package download
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func queuedRequest(id string, submitter string, priority int, offset int) *Request {
+	return &Request{
+		ID:            id,
+		Submitter:     submitter,
+		Priority:      priority,
+		TimeRequested: time.Unix(int64(offset), 0)}
+}
+
+func TestDispatchQueuePriorityOrder(t *testing.T) {
+	q := NewDispatchQueue()
+	q.Push(queuedRequest("low", "a", 0, 1))
+	q.Push(queuedRequest("high", "a", 5, 2))
+
+	r := q.Pop()
+	if r.ID != "high" {
+		t.Errorf("Pop: expected %s, got %s", "high", r.ID)
+	}
+}
+
+func TestDispatchQueueFairSharing(t *testing.T) {
+	q := NewDispatchQueue()
+	for i := 0; i < 5; i++ {
+		q.Push(queuedRequest(fmt.Sprintf("bulk-%d", i), "bulk", 0, i))
+	}
+	q.Push(queuedRequest("interactive", "user", 0, 10))
+
+	if q.Position("interactive") != 2 {
+		t.Errorf("Position: expected %d, got %d", 2, q.Position("interactive"))
+	}
+
+	first, second := q.Pop(), q.Pop()
+	if first.ID != "bulk-0" || second.ID != "interactive" {
+		t.Errorf("Pop order: expected bulk-0, interactive, got %s, %s", first.ID, second.ID)
+	}
+
+	if q.Len() != 4 {
+		t.Errorf("Len: expected %d, got %d", 4, q.Len())
+	}
+}
+
+func TestDispatchQueuePriorityDoesNotStarveOthers(t *testing.T) {
+	q := NewDispatchQueue()
+	for i := 0; i < 5; i++ {
+		q.Push(queuedRequest(fmt.Sprintf("greedy-%d", i), "greedy", MaxPriority, i))
+	}
+	q.Push(queuedRequest("interactive", "user", MinPriority, 10))
+
+	first, second := q.Pop(), q.Pop()
+	if first.ID != "greedy-0" || second.ID != "interactive" {
+		t.Errorf("Pop order: expected greedy-0, interactive, got %s, %s", first.ID, second.ID)
+	}
+
+	q.Pop()
+	if len(q.lastServed) != 1 {
+		t.Errorf("expected submitters with nothing queued to be forgotten, got %v", q.lastServed)
+	}
+}
+
+func TestDispatchQueueHoldsUntilNotBefore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+
+	q := NewDispatchQueue()
+	q.Clock = clock
+
+	later := queuedRequest("later", "a", 9, 1)
+	later.NotBefore = clock.now.Add(time.Hour)
+	expired := queuedRequest("expired", "a", 0, 2)
+	expired.NotBefore = clock.now.Add(time.Hour)
+	expired.Deadline = clock.now.Add(-time.Minute)
+	now := queuedRequest("now", "b", 0, 3)
+	q.Push(later)
+	q.Push(expired)
+	q.Push(now)
+
+	if n := q.ReadyLen(clock.now); n != 2 {
+		t.Errorf("ReadyLen: expected 2, got %d", n)
+	}
+
+	first, _ := q.NextReady(nil)
+	second, _ := q.NextReady(nil)
+	if first.ID != "expired" || second.ID != "now" {
+		t.Errorf("NextReady order: expected expired, now, got %s, %s", first.ID, second.ID)
+	}
+
+	clock.now = clock.now.Add(time.Hour)
+	third, _ := q.NextReady(nil)
+	if third.ID != "later" {
+		t.Errorf("NextReady: expected %s, got %s", "later", third.ID)
+	}
+}
@@ -1,10 +1,7 @@
 package download
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"strings"
@@ -17,6 +14,7 @@ type Download struct {
 	URL           string
 	Checksum      string
 	ChecksumType  string
+	Checksums     []Checksum
 	Metadata      *Metadata
 	Status        *Status
 	TimeStarted   time.Time
@@ -32,6 +30,7 @@ func NewDownload(id string, request *Request, downloadTime time.Time) *Download
 		URL:           request.URL,
 		Checksum:      request.Checksum,
 		ChecksumType:  request.ChecksumType,
+		Checksums:     request.ExpectedChecksums(),
 		Metadata:      request.Metadata,
 		Status:        &Status{},
 		TimeRequested: downloadTime,
@@ -64,36 +63,57 @@ func (d *Download) AverageBytesPerSecond() float32 {
 	return float32(float64(d.Status.BytesRead) / d.Duration().Seconds())
 }
 
-// ValidateChecksum ...
+// ValidateChecksum returns the normalized checksum type, defaulting to
+// sha256 when none is given. Unknown types are an error.
 func (d *Download) ValidateChecksum(checksumType string) (string, error) {
-	switch strings.ToLower(checksumType) {
-	case "md5":
-		return checksumType, nil
-	case "sha1":
-		return checksumType, nil
-	case "sha256":
-		return checksumType, nil
-	case "sha512":
-		return checksumType, nil
+	if checksumType == "" {
+		return "sha256", nil
 	}
 
-	return "sha256", fmt.Errorf("No hash found for %s defaulting to %s", d.ChecksumType, "sha256")
+	algorithm, err := LookupChecksumAlgorithm(checksumType)
+	if err != nil {
+		return "", err
+	}
+	return algorithm.Name, nil
 }
 
 // Hash ...
 func (d *Download) Hash() (hash.Hash, error) {
-	switch strings.ToLower(d.ChecksumType) {
-	case "md5":
-		return md5.New(), nil
-	case "sha1":
-		return sha1.New(), nil
-	case "sha256":
-		return sha256.New(), nil
-	case "sha512":
-		return sha512.New(), nil
+	algorithm, err := LookupChecksumAlgorithm(d.ChecksumType)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid checksum type %s", d.ChecksumType)
 	}
+	return algorithm.New(), nil
+}
 
-	return nil, fmt.Errorf("Invalid checksum type %s", d.ChecksumType)
+// Hashes returns a hash for each expected checksum type, keyed by type.
+func (d *Download) Hashes() (map[string]hash.Hash, error) {
+	hashes := make(map[string]hash.Hash)
+	for _, c := range d.Checksums {
+		algorithm, err := LookupChecksumAlgorithm(c.Type)
+		if err != nil {
+			return nil, err
+		}
+		hashes[algorithm.Name] = algorithm.New()
+	}
+	return hashes, nil
+}
+
+// VerifyChecksums checks every expected checksum against hashes, which
+// must have been written with the downloaded data.
+func (d *Download) VerifyChecksums(hashes map[string]hash.Hash) error {
+	for _, c := range d.Checksums {
+		h, ok := hashes[NormalizeChecksumType(c.Type)]
+		if !ok {
+			return fmt.Errorf("no %s hash computed", c.Type)
+		}
+
+		actual := hex.EncodeToString(h.Sum(nil))
+		if actual != strings.ToLower(c.Value) {
+			return fmt.Errorf("%s checksum mismatch: expected %s, got %s", c.Type, c.Value, actual)
+		}
+	}
+	return nil
 }
 
 // AddStatusUpdate ...
@@ -0,0 +1,118 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by dependencies that can report whether they are
+// currently usable.
+type Pinger interface {
+	Ping(context.Context) error
+}
+
+// HealthCheck ...
+type HealthCheck struct {
+	Name  string
+	Check func(context.Context) error
+}
+
+// HealthResult ...
+type HealthResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Readiness runs health checks to decide whether an instance should
+// receive traffic.
+type Readiness struct {
+	sync.Mutex
+	// Timeout bounds each check, so a hung dependency fails its check
+	// rather than the whole probe.
+	Timeout time.Duration
+	checks  []HealthCheck
+}
+
+// NewReadiness ...
+func NewReadiness(timeout time.Duration) *Readiness {
+	return &Readiness{Timeout: timeout}
+}
+
+// Add adds a check, which must return nil while its dependency is usable.
+func (r *Readiness) Add(name string, check func(context.Context) error) {
+	r.Lock()
+	defer r.Unlock()
+	r.checks = append(r.checks, HealthCheck{Name: name, Check: check})
+}
+
+// AddPinger adds a check that pings p.
+func (r *Readiness) AddPinger(name string, p Pinger) {
+	r.Add(name, p.Ping)
+}
+
+// Check runs every check concurrently, returning whether all of them passed
+// and their results in the order they were added.
+func (r *Readiness) Check(ctx context.Context) (bool, []HealthResult) {
+	r.Lock()
+	checks := make([]HealthCheck, len(r.checks))
+	copy(checks, r.checks)
+	r.Unlock()
+
+	results := make([]HealthResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = r.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, result := range results {
+		if result.Err != nil {
+			ok = false
+		}
+	}
+	return ok, results
+}
+
+func (r *Readiness) run(ctx context.Context, check HealthCheck) HealthResult {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %v", time.Since(start).Round(time.Millisecond))
+	}
+
+	return HealthResult{Name: check.Name, Err: err, Duration: time.Since(start)}
+}
+
+// BacklogCheck returns a check that fails while more than max requests
+// are ready and waiting to be dispatched. Requests scheduled for later are
+// not counted, however many there are.
+func (s *RequestService) BacklogCheck(max int) func(context.Context) error {
+	return func(context.Context) error {
+		depth := s.queue.ReadyLen(s.Clock.Now())
+		if max > 0 && depth > max {
+			return fmt.Errorf("%d requests waiting for dispatch, more than %d", depth, max)
+		}
+		return nil
+	}
+}
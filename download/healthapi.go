@@ -0,0 +1,36 @@
+package download
+
+import (
+	"time"
+
+	"github.com/patdowney/downloaderd-request/api"
+)
+
+// Health statuses.
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+func healthStatus(ok bool) string {
+	if ok {
+		return HealthOK
+	}
+	return HealthUnavailable
+}
+
+// ToAPIHealth ...
+func ToAPIHealth(ok bool, results []HealthResult) *api.Health {
+	h := &api.Health{Status: healthStatus(ok)}
+	for _, result := range results {
+		check := api.HealthCheck{
+			Name:       result.Name,
+			Status:     healthStatus(result.Err == nil),
+			DurationMS: float64(result.Duration) / float64(time.Millisecond)}
+		if result.Err != nil {
+			check.Error = RedactText(result.Err.Error())
+		}
+		h.Checks = append(h.Checks, check)
+	}
+	return h
+}
@@ -0,0 +1,157 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/common"
+)
+
+type hostState struct {
+	active     int
+	lastStart  time.Time
+	retryAfter time.Time
+}
+
+// HostDeferredError is returned by Acquire when the host has asked for
+// operations to be deferred until after the wait allowed.
+type HostDeferredError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *HostDeferredError) Error() string {
+	return fmt.Sprintf("host %s is deferred until %v", e.Host, e.Until)
+}
+
+// HostLimiter limits concurrent operations against each host, spaces them
+// at least MinDelay apart and defers them while the host has asked for a
+// Retry-After. Zero values disable each limit.
+type HostLimiter struct {
+	sync.Mutex
+	Clock         common.Clock
+	MaxConcurrent int
+	MinDelay      time.Duration
+	hosts         map[string]*hostState
+}
+
+// NewHostLimiter ...
+func NewHostLimiter(maxConcurrent int, minDelay time.Duration) *HostLimiter {
+	return &HostLimiter{
+		Clock:         &common.RealClock{},
+		MaxConcurrent: maxConcurrent,
+		MinDelay:      minDelay,
+		hosts:         make(map[string]*hostState)}
+}
+
+func (l *HostLimiter) state(host string) *hostState {
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostState{}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// Ready reports whether an operation against host may start now, and if
+// not, roughly how long until it may. A full host returns a zero wait as
+// it becomes ready on Release rather than after a time.
+func (l *HostLimiter) Ready(host string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	return l.ready(host)
+}
+
+func (l *HostLimiter) ready(host string) (bool, time.Duration) {
+	h, ok := l.hosts[host]
+	if !ok {
+		return true, 0
+	}
+
+	now := l.Clock.Now()
+	if h.active == 0 && !now.Before(h.retryAfter) && !now.Before(h.lastStart.Add(l.MinDelay)) {
+		delete(l.hosts, host)
+		return true, 0
+	}
+	if now.Before(h.retryAfter) {
+		return false, h.retryAfter.Sub(now)
+	}
+	if l.MaxConcurrent > 0 && h.active >= l.MaxConcurrent {
+		return false, 0
+	}
+	if next := h.lastStart.Add(l.MinDelay); now.Before(next) {
+		return false, next.Sub(now)
+	}
+	return true, 0
+}
+
+// Start records an operation against host as started. It should only be
+// called once Ready has returned true.
+func (l *HostLimiter) Start(host string) {
+	l.Lock()
+	defer l.Unlock()
+	h := l.state(host)
+	h.active++
+	h.lastStart = l.Clock.Now()
+}
+
+// Acquire waits until an operation against host may start and starts it,
+// or returns an error if that would take longer than maxWait or ctx is done
+// first. The error is a HostDeferredError if the host is deferred.
+func (l *HostLimiter) Acquire(ctx context.Context, host string, maxWait time.Duration) error {
+	deadline := l.Clock.Now().Add(maxWait)
+	for {
+		l.Lock()
+		ready, wait := l.ready(host)
+		if ready {
+			h := l.state(host)
+			h.active++
+			h.lastStart = l.Clock.Now()
+			l.Unlock()
+			return nil
+		}
+
+		if wait <= 0 {
+			wait = 100 * time.Millisecond
+		}
+		if now := l.Clock.Now(); now.Add(wait).After(deadline) {
+			retryAfter := l.state(host).retryAfter
+			l.Unlock()
+			if now.Before(retryAfter) {
+				return &HostDeferredError{Host: host, Until: retryAfter}
+			}
+			return fmt.Errorf("host %s is busy or rate limited, try again later", host)
+		}
+		l.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Release ...
+func (l *HostLimiter) Release(host string) {
+	l.Lock()
+	defer l.Unlock()
+	h := l.state(host)
+	if h.active > 0 {
+		h.active--
+	}
+}
+
+// Defer holds back operations against host until the given time.
+func (l *HostLimiter) Defer(host string, until time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	h := l.state(host)
+	if until.After(h.retryAfter) {
+		h.retryAfter = until
+	}
+}
@@ -0,0 +1,84 @@
+package download
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestHostLimiterConcurrency(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+
+	l.Start("example.com")
+	if ready, _ := l.Ready("example.com"); ready {
+		t.Errorf("Ready: expected example.com to be full")
+	}
+	if ready, _ := l.Ready("example.org"); !ready {
+		t.Errorf("Ready: expected example.org to be ready")
+	}
+
+	l.Release("example.com")
+	if ready, _ := l.Ready("example.com"); !ready {
+		t.Errorf("Ready: expected example.com to be ready after release")
+	}
+}
+
+func TestHostLimiterDelayAndRetryAfter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := NewHostLimiter(0, 10*time.Second)
+	l.Clock = clock
+
+	l.Start("example.com")
+	l.Release("example.com")
+
+	ready, wait := l.Ready("example.com")
+	if ready || wait != 10*time.Second {
+		t.Errorf("Ready: expected wait of %v, got %v, %v", 10*time.Second, ready, wait)
+	}
+
+	clock.now = clock.now.Add(10 * time.Second)
+	l.Defer("example.com", clock.now.Add(time.Minute))
+
+	ready, wait = l.Ready("example.com")
+	if ready || wait != time.Minute {
+		t.Errorf("Ready: expected wait of %v, got %v, %v", time.Minute, ready, wait)
+	}
+}
+
+func TestHostLimiterAcquireStopsWhenCancelled(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+	l.Start("example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := l.Acquire(ctx, "example.com", time.Hour)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Acquire: expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestDispatchQueueSkipsBusyHost(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+	l.Start("busy.example.com")
+
+	q := NewDispatchQueue()
+	busy := queuedRequest("busy", "a", 5, 1)
+	busy.URL = "http://busy.example.com/file"
+	idle := queuedRequest("idle", "b", 0, 2)
+	idle.URL = "http://idle.example.com/file"
+	q.Push(busy)
+	q.Push(idle)
+
+	r, _ := q.NextReady(l)
+	if r.ID != "idle" {
+		t.Errorf("NextReady: expected %s, got %s", "idle", r.ID)
+	}
+}
@@ -0,0 +1,42 @@
+package download
+
+import "sync"
+
+type keyedLock struct {
+	sync.Mutex
+	holders int
+}
+
+// keyedMutex locks keys independently of each other. A key's mutex exists
+// only while it is held or waited for.
+type keyedMutex struct {
+	sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// Lock locks key and returns the func that unlocks it.
+func (m *keyedMutex) Lock(key string) func() {
+	m.Mutex.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]*keyedLock)
+	}
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.holders++
+	m.Mutex.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		m.Mutex.Lock()
+		l.holders--
+		if l.holders == 0 {
+			delete(m.locks, key)
+		}
+		m.Mutex.Unlock()
+	}
+}
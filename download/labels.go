@@ -0,0 +1,142 @@
+package download
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Limits on request labels.
+const (
+	MaxLabels           = 64
+	MaxLabelKeyLength   = 63
+	MaxLabelValueLength = 63
+)
+
+// ValidateLabels returns an error if any label key or value can't be used
+// in a selector.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("at most %d labels are allowed", MaxLabels)
+	}
+	for k, v := range labels {
+		if k == "" {
+			return fmt.Errorf("empty label key")
+		} else if len(k) > MaxLabelKeyLength {
+			return fmt.Errorf("label key '%s' is longer than %d characters", k, MaxLabelKeyLength)
+		} else if !validLabelText(k) {
+			return fmt.Errorf("label key '%s' may only contain letters, digits and '-_./'", k)
+		}
+
+		if len(v) > MaxLabelValueLength {
+			return fmt.Errorf("label '%s' value is longer than %d characters", k, MaxLabelValueLength)
+		} else if !validLabelText(v) {
+			return fmt.Errorf("label '%s' value may only contain letters, digits and '-_./'", k)
+		}
+	}
+	return nil
+}
+
+func validLabelText(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// LabelPair is how a label is indexed by the stores.
+func LabelPair(key string, value string) string {
+	return key + "=" + value
+}
+
+// LabelPairs returns the indexed form of labels, sorted.
+func LabelPairs(labels map[string]string) []string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, LabelPair(k, v))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// LabelRequirement is one clause of a LabelSelector.
+type LabelRequirement struct {
+	Key    string
+	Value  string
+	Negate bool
+	// Exists requirements match on the key alone.
+	Exists bool
+}
+
+// Matches ...
+func (lr LabelRequirement) Matches(labels map[string]string) bool {
+	v, ok := labels[lr.Key]
+	if lr.Exists {
+		return ok != lr.Negate
+	}
+	return (ok && v == lr.Value) != lr.Negate
+}
+
+// LabelSelector is a comma separated list of requirements, all of which
+// must match: 'key=value', 'key!=value', 'key' or '!key'.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector ...
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	ls := LabelSelector{}
+	for _, clause := range strings.Split(selector, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+
+		var lr LabelRequirement
+		if i := strings.Index(clause, "!="); i >= 0 {
+			lr = LabelRequirement{Key: clause[:i], Value: clause[i+2:], Negate: true}
+		} else if i := strings.Index(clause, "="); i >= 0 {
+			lr = LabelRequirement{Key: clause[:i], Value: clause[i+1:]}
+		} else if strings.HasPrefix(clause, "!") {
+			lr = LabelRequirement{Key: clause[1:], Exists: true, Negate: true}
+		} else {
+			lr = LabelRequirement{Key: clause, Exists: true}
+		}
+
+		lr.Key = strings.TrimSpace(lr.Key)
+		lr.Value = strings.TrimSpace(lr.Value)
+		if lr.Key == "" || !validLabelText(lr.Key) || !validLabelText(lr.Value) {
+			return nil, fmt.Errorf("invalid label selector clause '%s'", clause)
+		}
+		ls = append(ls, lr)
+	}
+
+	if len(ls) == 0 {
+		return nil, fmt.Errorf("empty label selector")
+	}
+	return ls, nil
+}
+
+// Matches ...
+func (ls LabelSelector) Matches(labels map[string]string) bool {
+	for _, lr := range ls {
+		if !lr.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexPair returns the label pair of the first equality requirement, which
+// stores can use to narrow a search before matching the whole selector.
+func (ls LabelSelector) IndexPair() (string, bool) {
+	for _, lr := range ls {
+		if !lr.Exists && !lr.Negate {
+			return LabelPair(lr.Key, lr.Value), true
+		}
+	}
+	return "", false
+}
@@ -11,6 +11,7 @@ type Metadata struct {
 	TimeRequested time.Time
 	MimeType      string
 	Size          uint64
+	SizeKnown     bool
 
 	// HTTP specific stuff
 	Server       string
@@ -18,22 +19,54 @@ type Metadata struct {
 	ETag         string
 	Expires      time.Time
 	StatusCode   int
+	RetryAfter   time.Time
 
 	Errors []string
 }
 
-func GetMetadataFromHead(requestTime time.Time, request *Request) (*Metadata, error) {
-	res, err := http.Head(request.URL)
-	if err != nil {
-		return nil, err
+// Deferred reports whether the origin asked for requests to wait, by
+// answering 429 Too Many Requests or 503 Service Unavailable.
+func (m *Metadata) Deferred() bool {
+	return m.StatusCode == http.StatusTooManyRequests || m.StatusCode == http.StatusServiceUnavailable
+}
+
+// Unchanged reports whether m describes the same version of a resource as
+// previous, either because the origin answered 304 Not Modified or because
+// the validators match.
+func (m *Metadata) Unchanged(previous *Metadata) bool {
+	if m.StatusCode == http.StatusNotModified {
+		return true
+	}
+	if previous == nil || m.StatusCode != http.StatusOK {
+		return false
+	}
+	if m.ETag != "" || previous.ETag != "" {
+		return m.ETag == previous.ETag
 	}
-	metadata := NewMetadata(request, res, requestTime)
+	return !m.LastModified.IsZero() && m.LastModified.Equal(previous.LastModified)
+}
 
-	return metadata, nil
+// timeFormats are the HTTP-date formats from RFC 7231 section 7.1.1.1,
+// followed by the numeric offset forms some servers send instead of GMT.
+var timeFormats = []string{
+	http.TimeFormat,
+	time.RFC850,
+	time.ANSIC,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Monday, 02-Jan-06 15:04:05 -0700",
 }
 
 func ParseTime(timeHeader string) (time.Time, error) {
-	return time.Parse(time.RFC1123, timeHeader)
+	var t time.Time
+	var err error
+	for _, layout := range timeFormats {
+		t, err = time.Parse(layout, timeHeader)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return t, err
 }
 
 func NewMetadata(request *Request, res *http.Response, requestTime time.Time) *Metadata {
@@ -50,20 +83,50 @@ func NewMetadata(request *Request, res *http.Response, requestTime time.Time) *M
 	var err error
 	// reference time: Mon Jan 2 15:04:05 -0700 MST 2006
 	contentLengthHeader := res.Header.Get("Content-Length")
-	m.Size, err = strconv.ParseUint(contentLengthHeader, 10, 64)
-	if err != nil {
-		m.Errors = append(m.Errors, err.Error())
+	if contentLengthHeader != "" {
+		m.Size, err = strconv.ParseUint(contentLengthHeader, 10, 64)
+		if err != nil {
+			m.Errors = append(m.Errors, err.Error())
+		} else {
+			m.SizeKnown = true
+		}
 	}
 
-	m.LastModified, err = ParseTime(res.Header.Get("Last-Modified"))
-	if err != nil {
-		m.Errors = append(m.Errors, err.Error())
+	lastModifiedHeader := res.Header.Get("Last-Modified")
+	if lastModifiedHeader != "" {
+		m.LastModified, err = ParseTime(lastModifiedHeader)
+		if err != nil {
+			m.Errors = append(m.Errors, err.Error())
+		}
 	}
 
-	m.Expires, err = ParseTime(res.Header.Get("Expires"))
-	if err != nil {
-		m.Errors = append(m.Errors, err.Error())
+	// RFC 7234 section 5.3: an invalid Expires value, such as "0" or "-1",
+	// means the response is already expired.
+	if _, present := res.Header["Expires"]; present {
+		m.Expires, err = ParseTime(res.Header.Get("Expires"))
+		if err != nil {
+			m.Expires = requestTime
+		}
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		m.RetryAfter = ParseRetryAfter(res.Header.Get("Retry-After"), requestTime)
 	}
 
 	return m
 }
+
+// ParseRetryAfter parses a Retry-After header given either as seconds or
+// as an HTTP-date, returning the zero time if it is missing or invalid.
+func ParseRetryAfter(retryAfterHeader string, now time.Time) time.Time {
+	seconds, err := strconv.ParseUint(retryAfterHeader, 10, 32)
+	if err == nil {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+
+	t, err := ParseTime(retryAfterHeader)
+	if err == nil {
+		return t
+	}
+	return time.Time{}
+}
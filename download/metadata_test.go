@@ -0,0 +1,44 @@
+package download
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseTimeFormats(t *testing.T) {
+	expected := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	headers := []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+		"Sun, 06 Nov 1994 09:49:37 +0100",
+	}
+
+	for _, h := range headers {
+		actual, err := ParseTime(h)
+		if err != nil {
+			t.Errorf("ParseTime('%s'): unexpected error %v", h, err)
+		} else if !actual.Equal(expected) {
+			t.Errorf("ParseTime('%s') = %v want %v", h, actual, expected)
+		}
+	}
+}
+
+func TestInvalidExpiresIsExpired(t *testing.T) {
+	requestTime := time.Date(2015, time.April, 1, 12, 0, 0, 0, time.UTC)
+
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	res.Header.Set("Expires", "-1")
+
+	m := NewMetadata(&Request{}, res, requestTime)
+
+	if !m.Expires.Equal(requestTime) {
+		t.Errorf("Expires: expected %v, got %v", requestTime, m.Expires)
+	}
+
+	if len(m.Errors) != 0 {
+		t.Errorf("Errors: expected %d errors, got %v", 0, m.Errors)
+	}
+}
@@ -0,0 +1,247 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/patdowney/downloaderd-request/tracing"
+)
+
+// maxChecksumFileSize limits how much of a checksum file is read.
+const maxChecksumFileSize = 1 << 20
+
+// MetadataClient ...
+type MetadataClient struct {
+	Client         *http.Client
+	RedirectPolicy *RedirectPolicy
+	AddressGuard   *AddressGuard
+	HostLimiter    *HostLimiter
+	MaxHostWait    time.Duration
+
+	// Policies returns the policy redirect targets are checked against for
+	// a tenant, or nil to follow any redirect the RedirectPolicy allows.
+	Policies func(tenant string) *Policy
+}
+
+// NewMetadataClient returns a client whose connections are checked against
+// addressGuard, or an unguarded client if addressGuard is nil.
+func NewMetadataClient(redirectPolicy *RedirectPolicy, addressGuard *AddressGuard) *MetadataClient {
+	c := &MetadataClient{
+		Client:         &http.Client{},
+		RedirectPolicy: redirectPolicy,
+		AddressGuard:   addressGuard,
+		HostLimiter:    NewHostLimiter(0, 0),
+		MaxHostWait:    30 * time.Second}
+
+	if addressGuard != nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   addressGuard.Control}
+
+		// no proxy, otherwise the guard would only see the proxy address
+		c.Client.Transport = &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second}
+	}
+
+	return c
+}
+
+// CheckHost ...
+func (c *MetadataClient) CheckHost(host string) error {
+	if c.AddressGuard == nil {
+		return nil
+	}
+	return c.AddressGuard.CheckHost(host)
+}
+
+// checkRedirect applies the redirect policy and the tenant's policy to each
+// redirect followed for request. The request's secret headers are only
+// sent to the host it was made to.
+func (c *MetadataClient) checkRedirect(request *Request) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != via[0].URL.Host {
+			request.RemoveSecretHeaders(req.Header)
+		}
+
+		err := c.RedirectPolicy.CheckRedirect(req, via)
+		if err != nil || c.Policies == nil {
+			return err
+		}
+		if policy := c.Policies(request.Tenant); policy != nil {
+			return policy.CheckURL(req.URL)
+		}
+		return nil
+	}
+}
+
+// GetMetadataFromHead ...
+func (c *MetadataClient) GetMetadataFromHead(ctx context.Context, requestTime time.Time, request *Request) (*Metadata, error) {
+	return c.head(ctx, "MetadataClient.GetMetadataFromHead", requestTime, request, nil)
+}
+
+// GetConditionalMetadata probes the request with If-None-Match and
+// If-Modified-Since taken from previous. A 304 Not Modified response is
+// returned as metadata with that status code.
+func (c *MetadataClient) GetConditionalMetadata(ctx context.Context, requestTime time.Time, request *Request, previous *Metadata) (*Metadata, error) {
+	conditions := make(http.Header)
+	if previous != nil {
+		if previous.ETag != "" {
+			conditions.Set("If-None-Match", previous.ETag)
+		}
+		if !previous.LastModified.IsZero() {
+			conditions.Set("If-Modified-Since", previous.LastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+	return c.head(ctx, "MetadataClient.GetConditionalMetadata", requestTime, request, conditions)
+}
+
+// head probes the request in a span with the given name. Trace context
+// isn't sent to the origin, which is outside our control.
+func (c *MetadataClient) head(ctx context.Context, spanName string, requestTime time.Time, request *Request, conditions http.Header) (m *Metadata, err error) {
+	ctx, span := startSpan(ctx, spanName, tracing.KindClient, request)
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("http.method", "HEAD")
+	span.SetAttribute("url.full", RedactURL(request.URL))
+
+	request.RedirectChain = make([]Redirect, 0)
+
+	// copy the client so the redirect chain is recorded per request
+	client := *c.Client
+	checkRedirect := c.checkRedirect(request)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.Response != nil {
+			request.RedirectChain = append(request.RedirectChain, Redirect{
+				URL:        req.Response.Request.URL.String(),
+				StatusCode: req.Response.StatusCode,
+				Location:   req.URL.String()})
+		}
+		return checkRedirect(req, via)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", request.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = request.OriginHeaders()
+	for k, v := range conditions {
+		req.Header[k] = v
+	}
+
+	err = c.HostLimiter.Acquire(ctx, req.URL.Host, c.MaxHostWait)
+	if err != nil {
+		return nil, err
+	}
+	defer c.HostLimiter.Release(req.URL.Host)
+
+	probes := inFlight.With("probe")
+	probes.Inc()
+	start := time.Now()
+	res, err := client.Do(req)
+	probes.Dec()
+	if err != nil {
+		probeDuration.With("error").ObserveSince(start)
+		Logger(request).Warn("metadata-probe-error", "url", request.URL, "error", err)
+		// a redirect to a url the policy rejects is rejected like the url
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			return nil, policyErr
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	probeDuration.With(strconv.Itoa(res.StatusCode)).ObserveSince(start)
+	span.SetAttribute("http.status_code", res.StatusCode)
+	span.SetAttribute("http.redirects", len(request.RedirectChain))
+	Logger(request).Debug("metadata-probe", "url", request.URL, "status", res.StatusCode,
+		"redirects", len(request.RedirectChain), "duration", time.Since(start))
+
+	request.ResolvedURL = res.Request.URL.String()
+	metadata := NewMetadata(request, res, requestTime)
+
+	return metadata, nil
+}
+
+// GetChecksumFromURL fetches the request's ChecksumURL and sets Checksum and
+// ChecksumType from the entry matching the requested file name. The URL and
+// any redirects are checked against the tenant's policy.
+func (c *MetadataClient) GetChecksumFromURL(ctx context.Context, request *Request) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", request.ChecksumURL, nil)
+	if err != nil {
+		return err
+	}
+
+	origin, err := url.Parse(request.URL)
+	if err != nil {
+		return err
+	}
+
+	// the policy may have changed since the request, or its schedule, was
+	// accepted
+	if c.Policies != nil {
+		if policy := c.Policies(request.Tenant); policy != nil {
+			err = policy.CheckURL(req.URL)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// only send origin headers and credentials back to the same host
+	if origin.Host == req.URL.Host {
+		req.Header = request.OriginHeaders()
+	}
+
+	client := *c.Client
+	client.CheckRedirect = c.checkRedirect(request)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("checksum url returned status %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxChecksumFileSize))
+	if err != nil {
+		return err
+	}
+
+	filename := path.Base(origin.Path)
+	if request.ResolvedURL != "" {
+		resolved, err := url.Parse(request.ResolvedURL)
+		if err == nil {
+			filename = path.Base(resolved.Path)
+		}
+	}
+
+	checksum, checksumType, err := ParseChecksumFile(string(body), res.Request.URL.Path, filename)
+	if err != nil && path.Base(origin.Path) != filename {
+		checksum, checksumType, err = ParseChecksumFile(string(body), res.Request.URL.Path, path.Base(origin.Path))
+	}
+	if err != nil {
+		return err
+	}
+
+	if request.ChecksumType != "" {
+		checksumType = request.ChecksumType
+	}
+
+	request.Checksum, request.ChecksumType, err = NormalizeChecksum(checksum, checksumType)
+	return err
+}
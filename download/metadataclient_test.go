@@ -0,0 +1,198 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRedirectServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/first", func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/second", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/second", func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRedirectChainRecorded(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	c := NewMetadataClient(NewRedirectPolicy(), nil)
+	r := &Request{URL: server.URL + "/first"}
+
+	_, err := c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.RedirectChain) != 2 {
+		t.Fatalf("RedirectChain: expected %d redirects, got %d", 2, len(r.RedirectChain))
+	}
+
+	if r.RedirectChain[0].StatusCode != http.StatusMovedPermanently {
+		t.Errorf("RedirectChain[0].StatusCode: expected %d, got %d", http.StatusMovedPermanently, r.RedirectChain[0].StatusCode)
+	}
+
+	expectedURL := server.URL + "/final"
+	if r.ResolvedURL != expectedURL {
+		t.Errorf("ResolvedURL: expected %s, got %s", expectedURL, r.ResolvedURL)
+	}
+
+	if r.ResourceKey().URL != expectedURL {
+		t.Errorf("ResourceKey().URL: expected %s, got %s", expectedURL, r.ResourceKey().URL)
+	}
+}
+
+func TestMaxRedirectsEnforced(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	c := NewMetadataClient(&RedirectPolicy{MaxRedirects: 1, AllowCrossHost: true}, nil)
+	r := &Request{URL: server.URL + "/first"}
+
+	_, err := c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if err == nil {
+		t.Errorf("GetMetadataFromHead: expected error after %d redirect", 1)
+	}
+}
+
+func TestPolicyAppliedToRedirects(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	policy := &Policy{DenyURLPatterns: []string{"/final$"}}
+	err := policy.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMetadataClient(NewRedirectPolicy(), nil)
+	c.Policies = func(string) *Policy { return policy }
+	r := &Request{URL: server.URL + "/first"}
+
+	_, err = c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if policyErr, ok := err.(*PolicyError); !ok || policyErr.Code != PolicyURLDenied {
+		t.Errorf("GetMetadataFromHead: expected %s, got %v", PolicyURLDenied, err)
+	}
+}
+
+func TestSecretHeadersNotSentToOtherHosts(t *testing.T) {
+	var received http.Header
+	other := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+	}))
+	defer other.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, other.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	c := NewMetadataClient(NewRedirectPolicy(), nil)
+	r := &Request{
+		URL:     origin.URL + "/file",
+		Headers: map[string]string{"X-Public": "a"},
+		Secrets: &Secrets{
+			Headers:     map[string]string{"X-Api-Key": "secret"},
+			Credentials: &Credentials{Token: "token"}}}
+
+	_, err := c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Get("X-Api-Key") != "" || received.Get("Authorization") != "" {
+		t.Errorf("expected secret headers to be removed on a redirect to another host, got %v", received)
+	}
+	if received.Get("X-Public") != "a" {
+		t.Errorf("expected other headers to be kept, got %v", received)
+	}
+}
+
+func TestAddressGuardBlocksLoopback(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	c := NewMetadataClient(NewRedirectPolicy(), NewAddressGuard())
+	r := &Request{URL: server.URL + "/final"}
+
+	_, err := c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if err == nil {
+		t.Errorf("GetMetadataFromHead('%s'): expected blocked address error", r.URL)
+	}
+}
+
+func TestAddressGuardAllowOverridesDeny(t *testing.T) {
+	server := newRedirectServer()
+	defer server.Close()
+
+	guard := NewAddressGuard()
+	guard.Allow, _ = ParseCIDRs([]string{"127.0.0.1/32"})
+
+	c := NewMetadataClient(NewRedirectPolicy(), guard)
+	r := &Request{URL: server.URL + "/final"}
+
+	_, err := c.GetMetadataFromHead(context.Background(), time.Now(), r)
+	if err != nil {
+		t.Errorf("GetMetadataFromHead('%s'): unexpected error %v", r.URL, err)
+	}
+}
+
+func TestConditionalMetadataNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", `"v2"`)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewMetadataClient(NewRedirectPolicy(), nil)
+
+	previous := &Metadata{ETag: `"v1"`, StatusCode: http.StatusOK}
+	m, err := c.GetConditionalMetadata(context.Background(), time.Now(), &Request{URL: server.URL}, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Unchanged(previous) {
+		t.Errorf("Unchanged: expected true for status %d", m.StatusCode)
+	}
+
+	previous = &Metadata{ETag: `"v0"`, StatusCode: http.StatusOK}
+	m, err = c.GetConditionalMetadata(context.Background(), time.Now(), &Request{URL: server.URL}, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Unchanged(previous) {
+		t.Errorf("Unchanged: expected false for etag %s", m.ETag)
+	}
+}
+
+func TestProbeStopsWhenCancelled(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := NewMetadataClient(NewRedirectPolicy(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := c.GetMetadataFromHead(ctx, time.Now(), &Request{URL: server.URL})
+	if err == nil {
+		t.Errorf("expected the probe to stop when its context is done")
+	}
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"context"
+	"time"
+
+	"github.com/patdowney/downloaderd-request/metrics"
+)
+
+// Request outcomes counted in downloaderd_requests_total.
+const (
+	OutcomeQueued         = "queued"
+	OutcomeScheduled      = "scheduled"
+	OutcomeFailed         = "failed"
+	OutcomePolicyRejected = "policy_rejected"
+	OutcomeQuotaRejected  = "quota_rejected"
+	OutcomeDispatched     = "dispatched"
+	OutcomeDispatchFailed = "dispatch_failed"
+	OutcomeExpired        = "expired"
+	OutcomeCancelled      = "cancelled"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("downloaderd_requests_total",
+		"Requests by outcome.", "outcome")
+	probeDuration = metrics.NewHistogramVec("downloaderd_probe_duration_seconds",
+		"Latency of metadata probes by response status.", nil, "status")
+	dispatchDuration = metrics.NewHistogramVec("downloaderd_dispatch_duration_seconds",
+		"Latency of dispatching requests to the download agent.", nil, "outcome")
+	inFlight = metrics.NewGaugeVec("downloaderd_in_flight_requests",
+		"Requests currently being probed or dispatched.", "stage")
+	storeDuration = metrics.NewHistogramVec("downloaderd_store_duration_seconds",
+		"Latency of request store operations.", nil, "backend", "operation")
+)
+
+// MetricsRequestStore records the latency of every operation on a
+// RequestStore.
+type MetricsRequestStore struct {
+	RequestStore
+	Backend string
+}
+
+// NewMetricsRequestStore ...
+func NewMetricsRequestStore(store RequestStore, backend string) *MetricsRequestStore {
+	return &MetricsRequestStore{RequestStore: store, Backend: backend}
+}
+
+func (s *MetricsRequestStore) observe(operation string, start time.Time) {
+	storeDuration.With(s.Backend, operation).ObserveSince(start)
+}
+
+// Add ...
+func (s *MetricsRequestStore) Add(ctx context.Context, r *Request) error {
+	defer s.observe("add", time.Now())
+	return s.RequestStore.Add(ctx, r)
+}
+
+// Update ...
+func (s *MetricsRequestStore) Update(ctx context.Context, r *Request) error {
+	defer s.observe("update", time.Now())
+	return s.RequestStore.Update(ctx, r)
+}
+
+// FindByID ...
+func (s *MetricsRequestStore) FindByID(ctx context.Context, tenant string, id string) (*Request, error) {
+	defer s.observe("find_by_id", time.Now())
+	return s.RequestStore.FindByID(ctx, tenant, id)
+}
+
+// FindByResourceKey ...
+func (s *MetricsRequestStore) FindByResourceKey(ctx context.Context, tenant string, key ResourceKey, offset uint, count uint) ([]*Request, error) {
+	defer s.observe("find_by_resource_key", time.Now())
+	return s.RequestStore.FindByResourceKey(ctx, tenant, key, offset, count)
+}
+
+// FindByIdempotencyKey ...
+func (s *MetricsRequestStore) FindByIdempotencyKey(ctx context.Context, tenant string, submitter string, key string) (*Request, error) {
+	defer s.observe("find_by_idempotency_key", time.Now())
+	return s.RequestStore.FindByIdempotencyKey(ctx, tenant, submitter, key)
+}
+
+// FindByLabels ...
+func (s *MetricsRequestStore) FindByLabels(ctx context.Context, tenant string, owner string, selector LabelSelector, offset uint, count uint) ([]*Request, error) {
+	defer s.observe("find_by_labels", time.Now())
+	return s.RequestStore.FindByLabels(ctx, tenant, owner, selector, offset, count)
+}
+
+// FindByOwner ...
+func (s *MetricsRequestStore) FindByOwner(ctx context.Context, tenant string, owner string, offset uint, count uint) ([]*Request, error) {
+	defer s.observe("find_by_owner", time.Now())
+	return s.RequestStore.FindByOwner(ctx, tenant, owner, offset, count)
+}
+
+// FindAll ...
+func (s *MetricsRequestStore) FindAll(ctx context.Context, tenant string, offset uint, count uint) ([]*Request, error) {
+	defer s.observe("find_all", time.Now())
+	return s.RequestStore.FindAll(ctx, tenant, offset, count)
+}
+
+// FindByStates ...
+func (s *MetricsRequestStore) FindByStates(ctx context.Context, states []string) ([]*Request, error) {
+	defer s.observe("find_by_states", time.Now())
+	return s.RequestStore.FindByStates(ctx, states)
+}
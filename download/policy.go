@@ -0,0 +1,158 @@
+package download
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Policy error codes reported in api.Error.Code.
+const (
+	PolicyHostDenied     = "host_denied"
+	PolicyHostNotAllowed = "host_not_allowed"
+	PolicyURLDenied      = "url_denied"
+	PolicyURLNotAllowed  = "url_not_allowed"
+	PolicyPortNotAllowed = "port_not_allowed"
+	PolicySizeExceeded   = "size_exceeded"
+	PolicySizeUnknown    = "size_unknown"
+)
+
+// PolicyError ...
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Policy restricts which origins may be requested. Empty allow lists
+// allow everything; deny rules are checked first.
+type Policy struct {
+	AllowHosts       []string `json:"allow_hosts"`
+	DenyHosts        []string `json:"deny_hosts"`
+	AllowURLPatterns []string `json:"allow_url_patterns"`
+	DenyURLPatterns  []string `json:"deny_url_patterns"`
+	AllowPorts       []int    `json:"allow_ports"`
+	MaxSize          uint64   `json:"max_size"`
+
+	allowURLs []*regexp.Regexp
+	denyURLs  []*regexp.Regexp
+}
+
+// Compile validates host globs and compiles the url patterns.
+func (p *Policy) Compile() error {
+	for _, glob := range append(p.AllowHosts, p.DenyHosts...) {
+		_, err := path.Match(glob, "")
+		if err != nil {
+			return fmt.Errorf("invalid host glob '%s': %v", glob, err)
+		}
+	}
+
+	var err error
+	p.allowURLs, err = compilePatterns(p.AllowURLPatterns)
+	if err != nil {
+		return err
+	}
+	p.denyURLs, err = compilePatterns(p.DenyURLPatterns)
+	return err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url pattern '%s': %v", pattern, err)
+		}
+		compiled[i] = re
+	}
+	return compiled, nil
+}
+
+func matchHost(globs []string, host string) bool {
+	for _, glob := range globs {
+		matched, _ := path.Match(strings.ToLower(glob), host)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchURL(patterns []*regexp.Regexp, u string) bool {
+	for _, re := range patterns {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func urlPort(u *url.URL) int {
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			return 443
+		}
+		return 80
+	}
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// CheckURL ...
+func (p *Policy) CheckURL(u *url.URL) error {
+	host := strings.ToLower(u.Hostname())
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+
+	if matchHost(p.DenyHosts, host) {
+		return &PolicyError{Code: PolicyHostDenied, Message: fmt.Sprintf("host %s is denied", host)}
+	}
+	if len(p.AllowHosts) > 0 && !matchHost(p.AllowHosts, host) {
+		return &PolicyError{Code: PolicyHostNotAllowed, Message: fmt.Sprintf("host %s is not allowed", host)}
+	}
+
+	if matchURL(p.denyURLs, u.String()) {
+		return &PolicyError{Code: PolicyURLDenied, Message: fmt.Sprintf("url %s is denied", u)}
+	}
+	if len(p.allowURLs) > 0 && !matchURL(p.allowURLs, u.String()) {
+		return &PolicyError{Code: PolicyURLNotAllowed, Message: fmt.Sprintf("url %s is not allowed", u)}
+	}
+
+	if len(p.AllowPorts) > 0 {
+		port := urlPort(u)
+		allowed := false
+		for _, ap := range p.AllowPorts {
+			if ap == port {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return &PolicyError{Code: PolicyPortNotAllowed, Message: fmt.Sprintf("port %d is not allowed", port)}
+		}
+	}
+
+	return nil
+}
+
+// CheckMetadata rejects resources larger than MaxSize, and those whose
+// size the origin doesn't report if MaxSize is set.
+func (p *Policy) CheckMetadata(m *Metadata) error {
+	if p.MaxSize > 0 && !m.SizeKnown {
+		return &PolicyError{Code: PolicySizeUnknown,
+			Message: fmt.Sprintf("size is unknown and must not exceed %d", p.MaxSize)}
+	}
+	if p.MaxSize > 0 && m.Size > p.MaxSize {
+		return &PolicyError{Code: PolicySizeExceeded,
+			Message: fmt.Sprintf("size %d exceeds maximum %d", m.Size, p.MaxSize)}
+	}
+	return nil
+}
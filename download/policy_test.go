@@ -0,0 +1,65 @@
+package download
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	p := &Policy{
+		AllowHosts:      []string{"*.example.com", "example.com"},
+		DenyHosts:       []string{"internal.example.com"},
+		DenyURLPatterns: []string{`\.exe$`},
+		AllowPorts:      []int{80, 443}}
+
+	err := p.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"http://example.com/file.iso":              "",
+		"https://cdn.example.com/file.iso":         "",
+		"http://internal.example.com/file.iso":     PolicyHostDenied,
+		"http://example.org/file.iso":              PolicyHostNotAllowed,
+		"http://cdn.example.com/setup.exe":         PolicyURLDenied,
+		"http://cdn.example.com:8080/file.iso":     PolicyPortNotAllowed,
+		"https://CDN.Example.com/file.iso":         "",
+		"http://example.com.attacker.org/file.iso": PolicyHostNotAllowed,
+	}
+
+	for rawURL, expectedCode := range cases {
+		u, _ := url.Parse(rawURL)
+		err := p.CheckURL(u)
+
+		actualCode := ""
+		if policyErr, ok := err.(*PolicyError); ok {
+			actualCode = policyErr.Code
+		} else if err != nil {
+			t.Errorf("CheckURL('%s'): unexpected error %v", rawURL, err)
+		}
+
+		if actualCode != expectedCode {
+			t.Errorf("CheckURL('%s') = '%s' want '%s'", rawURL, actualCode, expectedCode)
+		}
+	}
+}
+
+func TestPolicyCheckMetadata(t *testing.T) {
+	p := &Policy{MaxSize: 1024}
+
+	err := p.CheckMetadata(&Metadata{Size: 2048, SizeKnown: true})
+	if policyErr, ok := err.(*PolicyError); !ok || policyErr.Code != PolicySizeExceeded {
+		t.Errorf("CheckMetadata: expected %s, got %v", PolicySizeExceeded, err)
+	}
+
+	err = p.CheckMetadata(&Metadata{})
+	if policyErr, ok := err.(*PolicyError); !ok || policyErr.Code != PolicySizeUnknown {
+		t.Errorf("CheckMetadata: expected %s, got %v", PolicySizeUnknown, err)
+	}
+
+	err = p.CheckMetadata(&Metadata{Size: 512, SizeKnown: true})
+	if err != nil {
+		t.Errorf("CheckMetadata: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package download
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// PolicyFile loads a Policy from a JSON file and reloads it whenever the
+// file's modification time changes.
+type PolicyFile struct {
+	sync.RWMutex
+	Path    string
+	policy  *Policy
+	modTime time.Time
+}
+
+// NewPolicyFile ...
+func NewPolicyFile(path string) (*PolicyFile, error) {
+	f := &PolicyFile{Path: path}
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload ...
+func (f *PolicyFile) Reload() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var p Policy
+	err = json.NewDecoder(file).Decode(&p)
+	if err != nil {
+		return err
+	}
+
+	err = p.Compile()
+	if err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	f.policy = &p
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+// Policy returns the current policy, reloading the file first if it has
+// changed. A file that fails to reload leaves the previous policy active.
+func (f *PolicyFile) Policy() *Policy {
+	info, err := os.Stat(f.Path)
+	if err == nil {
+		f.RLock()
+		changed := !info.ModTime().Equal(f.modTime)
+		f.RUnlock()
+
+		if changed {
+			err = f.Reload()
+			if err != nil {
+				slog.Error("policy-reload-error", "error", err)
+				f.Lock()
+				f.modTime = info.ModTime()
+				f.Unlock()
+			}
+		}
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+	return f.policy
+}
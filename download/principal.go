@@ -0,0 +1,46 @@
+package download
+
+// RoleAdmin may read and cancel any request.
+const RoleAdmin = "admin"
+
+// Principal is an authenticated client.
+type Principal struct {
+	ID     string
+	Tenant string
+	Roles  []string
+}
+
+// HasRole ...
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin ...
+func (p *Principal) IsAdmin() bool {
+	return p.HasRole(RoleAdmin)
+}
+
+// CanAccess returns true if the request belongs to the principal's tenant
+// and the principal owns it or is an admin. Requests made before
+// authentication was enabled have no owner and are only accessible to
+// admins.
+func (p *Principal) CanAccess(r *Request) bool {
+	return p.owns(r.Tenant, r.Owner)
+}
+
+// CanAccessSchedule applies the same rules as CanAccess to a schedule.
+func (p *Principal) CanAccessSchedule(s *Schedule) bool {
+	return p.owns(s.Tenant, s.Owner)
+}
+
+func (p *Principal) owns(tenant string, owner string) bool {
+	if tenant != p.Tenant {
+		return false
+	}
+	return p.IsAdmin() || (owner != "" && owner == p.ID)
+}
@@ -0,0 +1,268 @@
+package download
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/common"
+)
+
+// Quota names reported in QuotaError and api.Error.Quota.
+const (
+	QuotaDailyRequests = "daily_requests"
+	QuotaQueued        = "queued"
+	QuotaDailyBytes    = "daily_bytes"
+)
+
+// QuotaExceeded is the api.Error.Code of a QuotaError.
+const QuotaExceeded = "quota_exceeded"
+
+// Quota limits how much a client or tenant may request. Zero limits are
+// unlimited.
+//
+// MaxQueued limits the requests waiting to be dispatched, not the downloads
+// in flight. Requests stop counting once the download agent accepts them:
+// the agent reports progress to each request's callback rather than to this
+// service, which therefore can't tell when a download finishes. Limiting
+// concurrent downloads is left to the agent.
+type Quota struct {
+	MaxRequestsPerDay int    `json:"max_requests_per_day,omitempty"`
+	MaxQueued         int    `json:"max_queued,omitempty"`
+	MaxBytesPerDay    uint64 `json:"max_bytes_per_day,omitempty"`
+}
+
+// QuotaError names the quota a request would exceed. SizeUnknown is set
+// if the request can't be counted against a byte quota because the origin
+// didn't report its size.
+type QuotaError struct {
+	Scope       string
+	Quota       string
+	Limit       uint64
+	Used        uint64
+	ResetAt     time.Time
+	SizeUnknown bool
+}
+
+func (e *QuotaError) Error() string {
+	if e.SizeUnknown {
+		return fmt.Sprintf("%s %s quota of %d applies and the origin didn't report the size", e.Scope, e.Quota, e.Limit)
+	}
+	return fmt.Sprintf("%s %s quota of %d exceeded, %d used", e.Scope, e.Quota, e.Limit, e.Used)
+}
+
+// QuotaUsage is a client's or tenant's usage for the current day.
+type QuotaUsage struct {
+	Day      time.Time
+	Requests uint64
+	Bytes    uint64
+	Queued   uint64
+}
+
+// QuotaTracker keeps each client's and tenant's usage in memory, daily
+// usage resets at midnight UTC.
+type QuotaTracker struct {
+	sync.Mutex
+	Clock        common.Clock
+	ClientQuota  *Quota
+	TenantQuotas func(tenant string) *Quota
+	usage        map[string]*QuotaUsage
+	reserves     int
+}
+
+// sweepInterval is how many reservations there are between removing usage
+// from earlier days with nothing queued, which behaves the same as none.
+const sweepInterval = 1000
+
+// NewQuotaTracker ...
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		Clock: &common.RealClock{},
+		usage: make(map[string]*QuotaUsage)}
+}
+
+// quotaKey is how a request's client and tenant are tracked.
+type quotaKey struct {
+	scope string
+	key   string
+	quota *Quota
+}
+
+func (t *QuotaTracker) keys(r *Request) []quotaKey {
+	var tenantQuota *Quota
+	if t.TenantQuotas != nil {
+		tenantQuota = t.TenantQuotas(r.Tenant)
+	}
+	return []quotaKey{
+		{scope: "client", key: "client:" + r.Tenant + "\x00" + r.Submitter, quota: t.ClientQuota},
+		{scope: "tenant", key: "tenant:" + r.Tenant, quota: tenantQuota}}
+}
+
+func today(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// current returns the usage for key, resetting the daily counts on a new
+// day.
+func (t *QuotaTracker) current(key string, now time.Time) *QuotaUsage {
+	day := today(now)
+	u, ok := t.usage[key]
+	if !ok {
+		u = &QuotaUsage{Day: day}
+		t.usage[key] = u
+	} else if !u.Day.Equal(day) {
+		u.Day = day
+		u.Requests = 0
+		u.Bytes = 0
+	}
+	return u
+}
+
+// size returns the request's size, and false if it isn't known yet because
+// the origin deferred its probe. It is counted once it is probed again.
+func (t *QuotaTracker) size(r *Request) (uint64, bool) {
+	if r.Metadata == nil || r.Metadata.Deferred() {
+		return 0, false
+	}
+	return r.Metadata.Size, true
+}
+
+func (t *QuotaTracker) sweep(now time.Time) {
+	t.reserves++
+	if t.reserves%sweepInterval != 0 {
+		return
+	}
+	day := today(now)
+	for k, u := range t.usage {
+		if u.Queued == 0 && !u.Day.Equal(day) {
+			delete(t.usage, k)
+		}
+	}
+}
+
+// checkBytes returns a QuotaError if the request's size would exceed a
+// byte quota, or if a byte quota applies and the origin didn't report it.
+func checkBytes(k quotaKey, u *QuotaUsage, r *Request, size uint64) error {
+	if k.quota.MaxBytesPerDay == 0 {
+		return nil
+	}
+	reset := u.Day.Add(24 * time.Hour)
+	if r.Metadata == nil || !r.Metadata.SizeKnown {
+		return &QuotaError{Scope: k.scope, Quota: QuotaDailyBytes, Limit: k.quota.MaxBytesPerDay, Used: u.Bytes, SizeUnknown: true}
+	}
+	if u.Bytes+size > k.quota.MaxBytesPerDay {
+		return &QuotaError{Scope: k.scope, Quota: QuotaDailyBytes, Limit: k.quota.MaxBytesPerDay, Used: u.Bytes, ResetAt: reset}
+	}
+	return nil
+}
+
+// Reserve counts the request against its client's and tenant's quotas,
+// returning a QuotaError without counting it if any would be exceeded.
+func (t *QuotaTracker) Reserve(r *Request) error {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.Clock.Now()
+	t.sweep(now)
+	size, sized := t.size(r)
+	keys := t.keys(r)
+
+	for _, k := range keys {
+		if k.quota == nil {
+			continue
+		}
+		u := t.current(k.key, now)
+		reset := u.Day.Add(24 * time.Hour)
+
+		if k.quota.MaxRequestsPerDay > 0 && u.Requests+1 > uint64(k.quota.MaxRequestsPerDay) {
+			return &QuotaError{Scope: k.scope, Quota: QuotaDailyRequests, Limit: uint64(k.quota.MaxRequestsPerDay), Used: u.Requests, ResetAt: reset}
+		}
+		if k.quota.MaxQueued > 0 && u.Queued+1 > uint64(k.quota.MaxQueued) {
+			return &QuotaError{Scope: k.scope, Quota: QuotaQueued, Limit: uint64(k.quota.MaxQueued), Used: u.Queued}
+		}
+		if sized {
+			err := checkBytes(k, u, r, size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	t.track(r, keys, now)
+	return nil
+}
+
+// ReserveBytes counts a request whose probe was deferred against its byte
+// quotas, once it has been probed again.
+func (t *QuotaTracker) ReserveBytes(r *Request) error {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.Clock.Now()
+	size, _ := t.size(r)
+	keys := t.keys(r)
+
+	for _, k := range keys {
+		if k.quota == nil {
+			continue
+		}
+		err := checkBytes(k, t.current(k.key, now), r, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		t.current(k.key, now).Bytes += size
+	}
+	return nil
+}
+
+// Track counts the request against its quotas without checking them, for
+// requests that were accepted before a restart.
+func (t *QuotaTracker) Track(r *Request) {
+	t.Lock()
+	defer t.Unlock()
+	t.track(r, t.keys(r), t.Clock.Now())
+}
+
+func (t *QuotaTracker) track(r *Request, keys []quotaKey, now time.Time) {
+	size, _ := t.size(r)
+	for _, k := range keys {
+		u := t.current(k.key, now)
+		u.Requests++
+		u.Bytes += size
+		u.Queued++
+	}
+}
+
+// Release marks the request as no longer queued.
+func (t *QuotaTracker) Release(r *Request) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, k := range t.keys(r) {
+		u, ok := t.usage[k.key]
+		if ok && u.Queued > 0 {
+			u.Queued--
+		}
+	}
+}
+
+// Usage returns the current usage of a client and of its tenant.
+func (t *QuotaTracker) Usage(tenant string, submitter string) (QuotaUsage, QuotaUsage) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.Clock.Now()
+	keys := t.keys(&Request{Tenant: tenant, Submitter: submitter})
+
+	return *t.current(keys[0].key, now), *t.current(keys[1].key, now)
+}
+
+// Quotas returns the quotas that apply to a client and to its tenant.
+func (t *QuotaTracker) Quotas(tenant string) (*Quota, *Quota) {
+	keys := t.keys(&Request{Tenant: tenant})
+	return keys[0].quota, keys[1].quota
+}
@@ -0,0 +1,114 @@
+package download
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQuotaTracker(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)}
+	quotas := NewQuotaTracker()
+	quotas.Clock = clock
+	quotas.ClientQuota = &Quota{MaxRequestsPerDay: 3, MaxQueued: 2, MaxBytesPerDay: 100}
+
+	request := func(size uint64) *Request {
+		return &Request{Submitter: "a", Metadata: &Metadata{Size: size, SizeKnown: true}}
+	}
+
+	first := request(40)
+	if err := quotas.Reserve(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := quotas.Reserve(request(40)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := quotas.Reserve(request(10))
+	if qe, ok := err.(*QuotaError); !ok || qe.Quota != QuotaQueued {
+		t.Fatalf("expected queued quota error, got %v", err)
+	}
+
+	quotas.Release(first)
+	err = quotas.Reserve(request(30))
+	if qe, ok := err.(*QuotaError); !ok || qe.Quota != QuotaDailyBytes {
+		t.Fatalf("expected daily_bytes quota error, got %v", err)
+	}
+
+	if err = quotas.Reserve(request(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	quotas.Release(first)
+	err = quotas.Reserve(request(0))
+	if qe, ok := err.(*QuotaError); !ok || qe.Quota != QuotaDailyRequests {
+		t.Fatalf("expected daily_requests quota error, got %v", err)
+	}
+
+	// daily usage resets at midnight, queued requests don't
+	clock.now = clock.now.Add(2 * time.Hour)
+	if err = quotas.Reserve(request(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := quotas.Usage("", "a")
+	if client.Requests != 1 || client.Bytes != 100 || client.Queued != 2 {
+		t.Errorf("unexpected usage %+v", client)
+	}
+}
+
+func TestQuotaTrackerUnknownSize(t *testing.T) {
+	quotas := NewQuotaTracker()
+	quotas.ClientQuota = &Quota{MaxBytesPerDay: 100}
+
+	err := quotas.Reserve(&Request{Submitter: "a", Metadata: &Metadata{StatusCode: 200}})
+	if qe, ok := err.(*QuotaError); !ok || !qe.SizeUnknown {
+		t.Fatalf("expected unknown size quota error, got %v", err)
+	}
+
+	// a deferred probe is counted once it is probed again
+	deferred := &Request{Submitter: "a", Metadata: &Metadata{StatusCode: 429}}
+	if err = quotas.Reserve(deferred); err != nil {
+		t.Fatal(err)
+	}
+	deferred.Metadata = &Metadata{StatusCode: 200, Size: 200, SizeKnown: true}
+	err = quotas.ReserveBytes(deferred)
+	if qe, ok := err.(*QuotaError); !ok || qe.Quota != QuotaDailyBytes {
+		t.Fatalf("expected daily_bytes quota error, got %v", err)
+	}
+}
+
+func TestQuotaTrackerSweepsEarlierDays(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	quotas := NewQuotaTracker()
+	quotas.Clock = clock
+
+	for i := 0; i < sweepInterval-1; i++ {
+		r := &Request{Submitter: fmt.Sprintf("client-%d", i)}
+		quotas.Reserve(r)
+		quotas.Release(r)
+	}
+
+	clock.now = clock.now.Add(24 * time.Hour)
+	quotas.Reserve(&Request{Submitter: "today"})
+	if len(quotas.usage) != 2 {
+		t.Errorf("expected only today's usage to be kept, got %d entries", len(quotas.usage))
+	}
+}
+
+func TestSchedulesShareTheirSubmittersQuota(t *testing.T) {
+	tracker := NewQuotaTracker()
+	tracker.ClientQuota = &Quota{MaxRequestsPerDay: 1}
+
+	first := &Schedule{ID: "first", Submitter: "client"}
+	second := &Schedule{ID: "second", Submitter: "client"}
+
+	err := tracker.Reserve(first.NewRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.Reserve(second.NewRequest())
+	if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("expected another schedule of the same client to be over quota, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package download
+
+import (
+	"time"
+
+	"github.com/patdowney/downloaderd-request/api"
+)
+
+// ToAPIQuotaStatus returns the usage and limits of a client and its tenant.
+func ToAPIQuotaStatus(t *QuotaTracker, tenant string, submitter string) *api.QuotaStatus {
+	clientUsage, tenantUsage := t.Usage(tenant, submitter)
+	clientQuota, tenantQuota := t.Quotas(tenant)
+	return &api.QuotaStatus{
+		Client: ToAPIQuotaUsage(clientUsage, clientQuota),
+		Tenant: ToAPIQuotaUsage(tenantUsage, tenantQuota)}
+}
+
+// ToAPIQuotaUsage ...
+func ToAPIQuotaUsage(usage QuotaUsage, quota *Quota) *api.QuotaUsage {
+	u := &api.QuotaUsage{
+		Day:      usage.Day,
+		ResetsAt: usage.Day.Add(24 * time.Hour),
+		Requests: usage.Requests,
+		Bytes:    usage.Bytes,
+		Queued:   usage.Queued}
+
+	if quota != nil {
+		u.Limits = &api.Quota{
+			MaxRequestsPerDay: quota.MaxRequestsPerDay,
+			MaxQueued:         quota.MaxQueued,
+			MaxBytesPerDay:    quota.MaxBytesPerDay}
+	}
+	return u
+}
+
+// ToAPIQuotaError ...
+func ToAPIQuotaError(e *QuotaError, errorTime time.Time) *api.Error {
+	return &api.Error{Time: errorTime, Error: e.Error(), Code: QuotaExceeded, Quota: e.Quota}
+}
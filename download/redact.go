@@ -0,0 +1,102 @@
+package download
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue replaces secrets in logged URLs.
+const RedactedValue = "REDACTED"
+
+// secretParamWords mark query parameters that carry credentials, matched
+// against the lower cased parameter name.
+var secretParamWords = []string{"token", "secret", "password", "passwd", "signature", "credential", "apikey", "api_key", "session", "auth"}
+
+// secretParams are matched exactly.
+var secretParams = map[string]bool{"key": true, "sig": true, "pwd": true, "code": true}
+
+// IsSecretParam returns true if a query parameter of this name should not
+// be logged.
+func IsSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	if secretParams[name] {
+		return true
+	}
+	for _, word := range secretParamWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURL replaces any user info and secret query parameters in rawURL.
+// Usernames are redacted as well as passwords, as tokens are often passed
+// as the username alone. Strings that don't parse as URLs are returned
+// unchanged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return rawURL
+	}
+
+	redacted := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(RedactedValue, RedactedValue)
+		} else {
+			u.User = url.User(RedactedValue)
+		}
+		redacted = true
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			if IsSecretParam(name) {
+				query.Set(name, RedactedValue)
+				redacted = true
+			}
+		}
+		if redacted {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	if !redacted {
+		return rawURL
+	}
+	return u.String()
+}
+
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// RedactText redacts every URL in s, such as those in net/url errors.
+func RedactText(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	return urlPattern.ReplaceAllStringFunc(s, RedactURL)
+}
+
+// RedactAttr is a slog.HandlerOptions.ReplaceAttr that redacts URLs in
+// string and error attributes.
+func RedactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, RedactText(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, RedactText(err.Error()))
+		}
+	}
+	return a
+}
+
+// Logger returns a logger for events about the request, carrying its id
+// and correlation id.
+func Logger(r *Request) *slog.Logger {
+	return slog.Default().With("request_id", r.ID, "correlation_id", r.CorrelationID)
+}
@@ -0,0 +1,48 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Redirect ...
+type Redirect struct {
+	URL        string
+	StatusCode int
+	Location   string
+}
+
+// RedirectPolicy ...
+type RedirectPolicy struct {
+	MaxRedirects   int
+	AllowCrossHost bool
+	AllowDowngrade bool
+}
+
+// NewRedirectPolicy returns the policy used when none is configured, which
+// matches the redirect limit of the default http client.
+func NewRedirectPolicy() *RedirectPolicy {
+	return &RedirectPolicy{
+		MaxRedirects:   10,
+		AllowCrossHost: true,
+		AllowDowngrade: false}
+}
+
+// CheckRedirect ...
+func (p *RedirectPolicy) CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > p.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", p.MaxRedirects)
+	}
+
+	previous := via[len(via)-1]
+	if !p.AllowDowngrade && previous.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect from %s to %s downgrades scheme", previous.URL, req.URL)
+	}
+
+	original := via[0]
+	if !p.AllowCrossHost && req.URL.Host != original.URL.Host {
+		return fmt.Errorf("redirect from %s to %s changes host", original.URL, req.URL)
+	}
+
+	return nil
+}
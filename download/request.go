@@ -1,29 +1,222 @@
 package download
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/url"
 	"time"
 )
 
+// Request states.
+const (
+	RequestScheduled  = "scheduled"
+	RequestQueued     = "queued"
+	RequestDispatched = "dispatched"
+	RequestFailed     = "failed"
+	RequestCancelled  = "cancelled"
+)
+
 type Request struct {
 	ID            string
 	URL           string
 	Checksum      string
 	ChecksumType  string
+	ChecksumURL   string
+	Checksums     []Checksum
 	TimeRequested time.Time
 	Callback      string
 	DownloadID    string
 	Errors        []*RequestError
 	Metadata      *Metadata
+	ResolvedURL   string
+	RedirectChain []Redirect
+	Headers       map[string]string
+	Secrets       *Secrets `json:"-" gorethink:"-"`
+	SealedSecrets string
+
+	PreviousRequestID string
+	NextRequestID     string
+
+	Priority  int
+	Submitter string
+	State     string
+
+	NotBefore time.Time
+	Deadline  time.Time
+
+	IdempotencyKey string
+	BodyHash       string
+
+	Labels map[string]string
+
+	// Owner is the id of the principal that made the request.
+	Owner  string
+	Tenant string
+
+	// CorrelationID is the id of the call that made the request, passed on
+	// to the download agent and logged with the request's events.
+	CorrelationID string
+
+	// TraceParent is the W3C trace context of the span that made the
+	// request, which its dispatch continues.
+	TraceParent string
+
+	// CallbackSecret is the tenant's secret, set only while dispatching.
+	CallbackSecret string `json:"-" gorethink:"-"`
 }
 
 func (r *Request) ResourceKey() ResourceKey {
 	rk := ResourceKey{URL: r.URL}
+	if r.ResolvedURL != "" {
+		rk.URL = r.ResolvedURL
+	}
 	if r.Metadata != nil {
 		rk.ETag = r.Metadata.ETag
 	}
 	return rk
 }
 
+// Copy returns a copy of r that can be changed without changing r. Errors
+// are copied as they are appended to in place; other fields are replaced
+// rather than changed.
+func (r *Request) Copy() *Request {
+	c := *r
+	c.Errors = append(make([]*RequestError, 0, len(r.Errors)), r.Errors...)
+	return &c
+}
+
 func (r *Request) AddError(requestError error, errorTime time.Time) {
 	r.Errors = append(r.Errors, NewRequestError(requestError, errorTime))
 }
+
+// DeadlinePassed ...
+func (r *Request) DeadlinePassed(now time.Time) bool {
+	return !r.Deadline.IsZero() && now.After(r.Deadline)
+}
+
+// Host returns the host the request is downloaded from, after redirects.
+func (r *Request) Host() string {
+	rawURL := r.URL
+	if r.ResolvedURL != "" {
+		rawURL = r.ResolvedURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// NewVersion returns a request for the same resource that follows r in its
+// version history. Fixed checksums are not carried over since they describe
+// the old version; a checksum url is fetched again.
+func (r *Request) NewVersion() *Request {
+	v := &Request{
+		URL:               r.URL,
+		ChecksumURL:       r.ChecksumURL,
+		Callback:          r.Callback,
+		Headers:           r.Headers,
+		Secrets:           r.Secrets,
+		PreviousRequestID: r.ID,
+		Priority:          r.Priority,
+		Submitter:         r.Submitter,
+		Labels:            r.Labels,
+		Owner:             r.Owner,
+		Tenant:            r.Tenant,
+		Errors:            make([]*RequestError, 0)}
+
+	if r.ChecksumURL != "" {
+		v.ChecksumType = r.ChecksumType
+	}
+
+	return v
+}
+
+// ExpectedChecksums returns the primary checksum followed by any
+// additional checksums, all of which must match the downloaded data.
+func (r *Request) ExpectedChecksums() []Checksum {
+	checksums := make([]Checksum, 0, len(r.Checksums)+1)
+	if r.Checksum != "" {
+		checksums = append(checksums, Checksum{Type: r.ChecksumType, Value: r.Checksum})
+	}
+	for _, c := range r.Checksums {
+		if c.Type != r.ChecksumType || c.Value != r.Checksum {
+			checksums = append(checksums, c)
+		}
+	}
+	return checksums
+}
+
+func (r *Request) secrets() *Secrets {
+	if r.Secrets == nil {
+		r.Secrets = &Secrets{Headers: make(map[string]string)}
+	}
+	return r.Secrets
+}
+
+// OriginHeaders returns the headers to send to the origin, including any
+// secret headers and credentials.
+func (r *Request) OriginHeaders() http.Header {
+	h := make(http.Header)
+	for k, v := range r.Headers {
+		h.Set(k, v)
+	}
+	if r.Secrets != nil {
+		for k, v := range r.Secrets.Headers {
+			h.Set(k, v)
+		}
+		if r.Secrets.Credentials != nil {
+			auth := r.Secrets.Credentials.AuthorizationHeader()
+			if auth != "" {
+				h.Set("Authorization", auth)
+			}
+		}
+	}
+	return h
+}
+
+// RemoveSecretHeaders removes the request's secret headers, and any
+// Authorization header, from h.
+func (r *Request) RemoveSecretHeaders(h http.Header) {
+	h.Del("Authorization")
+	if r.Secrets != nil {
+		for k := range r.Secrets.Headers {
+			h.Del(k)
+		}
+	}
+}
+
+// SealSecrets ...
+func (r *Request) SealSecrets(box *SecretBox) error {
+	if r.Secrets == nil {
+		return nil
+	}
+
+	plaintext, err := json.Marshal(r.Secrets)
+	if err != nil {
+		return err
+	}
+
+	r.SealedSecrets, err = box.Seal(plaintext)
+	return err
+}
+
+// OpenSecrets ...
+func (r *Request) OpenSecrets(box *SecretBox) error {
+	if r.SealedSecrets == "" {
+		return nil
+	}
+
+	plaintext, err := box.Open(r.SealedSecrets)
+	if err != nil {
+		return err
+	}
+
+	var secrets Secrets
+	err = json.Unmarshal(plaintext, &secrets)
+	if err != nil {
+		return err
+	}
+	r.Secrets = &secrets
+	return nil
+}
@@ -0,0 +1,140 @@
+package download
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/patdowney/downloaderd-request/api"
+)
+
+func TestSecretsSealedAtRest(t *testing.T) {
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	air := &api.IncomingRequest{
+		URL:         "http://example.com/some/resource",
+		Headers:     map[string]string{"user-agent": "downloaderd", "cookie": "session=abcde"},
+		Credentials: &api.Credentials{Token: "secret-token"}}
+
+	r, err := FromAPIIncomingRequest(air)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SealSecrets(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := json.Marshal(r)
+	for _, secret := range []string{"session=abcde", "secret-token"} {
+		if strings.Contains(string(stored), secret) {
+			t.Errorf("stored request contains secret '%s'", secret)
+		}
+	}
+
+	var loaded Request
+	json.Unmarshal(stored, &loaded)
+	err = loaded.OpenSecrets(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := loaded.OriginHeaders()
+	if h.Get("Authorization") != "Bearer secret-token" {
+		t.Errorf("Authorization: expected %s, got %s", "Bearer secret-token", h.Get("Authorization"))
+	}
+	if h.Get("Cookie") != "session=abcde" {
+		t.Errorf("Cookie: expected %s, got %s", "session=abcde", h.Get("Cookie"))
+	}
+	if h.Get("User-Agent") != "downloaderd" {
+		t.Errorf("User-Agent: expected %s, got %s", "downloaderd", h.Get("User-Agent"))
+	}
+}
+
+func TestHashIncomingRequestIgnoresFormatting(t *testing.T) {
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a, b, c api.IncomingRequest
+	json.Unmarshal([]byte(`{"url":"http://example.com/a","headers":{"X-A":"1","X-B":"2"}}`), &a)
+	json.Unmarshal([]byte(`{ "headers": {"X-B":"2", "X-A":"1"},
+		"url": "http://example.com/a" }`), &b)
+	json.Unmarshal([]byte(`{"url":"http://example.com/b"}`), &c)
+
+	if HashIncomingRequest(box, &a) != HashIncomingRequest(box, &b) {
+		t.Errorf("expected equivalent bodies to have the same hash")
+	}
+	if HashIncomingRequest(box, &a) == HashIncomingRequest(box, &c) {
+		t.Errorf("expected different bodies to have different hashes")
+	}
+
+	otherBox, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HashIncomingRequest(box, &a) == HashIncomingRequest(otherBox, &a) {
+		t.Errorf("expected hashes to be keyed")
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"team": "infra", "env": "prod"}
+
+	cases := []struct {
+		selector string
+		matches  bool
+	}{
+		{"team=infra", true},
+		{"team=infra,env=prod", true},
+		{"team=infra,env!=prod", false},
+		{"env!=dev", true},
+		{"team", true},
+		{"!team", false},
+		{"!owner", true},
+		{"owner=", false},
+	}
+
+	for _, c := range cases {
+		selector, err := ParseLabelSelector(c.selector)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.selector, err)
+			continue
+		}
+		if selector.Matches(labels) != c.matches {
+			t.Errorf("%s: expected match %v", c.selector, c.matches)
+		}
+	}
+
+	for _, invalid := range []string{"", "=infra", "team=in fra", "team=a=b"} {
+		_, err := ParseLabelSelector(invalid)
+		if err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestPrincipalCanAccessOnlyOwnTenant(t *testing.T) {
+	r := &Request{Owner: "ci", Tenant: "team-a"}
+
+	cases := []struct {
+		principal Principal
+		access    bool
+	}{
+		{Principal{ID: "ci", Tenant: "team-a"}, true},
+		{Principal{ID: "other", Tenant: "team-a"}, false},
+		{Principal{ID: "other", Tenant: "team-a", Roles: []string{RoleAdmin}}, true},
+		{Principal{ID: "ci", Tenant: "team-b"}, false},
+		{Principal{ID: "admin", Tenant: "team-b", Roles: []string{RoleAdmin}}, false},
+	}
+
+	for _, c := range cases {
+		if c.principal.CanAccess(r) != c.access {
+			t.Errorf("%v: expected access %v", c.principal, c.access)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package download
 
 import (
+	"encoding/json"
+	"net/http"
+	"time"
+
 	"github.com/patdowney/downloaderd-common/common"
 	"github.com/patdowney/downloaderd-request/api"
 )
@@ -23,9 +27,22 @@ func ToAPIRequest(orig *Request) *api.Request {
 		URL:                  orig.URL,
 		ExpectedChecksum:     orig.Checksum,
 		ExpectedChecksumType: orig.ChecksumType,
+		ChecksumURL:          orig.ChecksumURL,
 		DownloadID:           orig.DownloadID,
 		TimeRequested:        orig.TimeRequested,
 		Callback:             orig.Callback,
+		ResolvedURL:          orig.ResolvedURL,
+		Headers:              orig.Headers,
+		PreviousRequestID:    orig.PreviousRequestID,
+		NextRequestID:        orig.NextRequestID,
+		Priority:             orig.Priority,
+		Submitter:            orig.Submitter,
+		Owner:                orig.Owner,
+		Tenant:               orig.Tenant,
+		State:                orig.State,
+		NotBefore:            orig.NotBefore,
+		Deadline:             orig.Deadline,
+		Labels:               orig.Labels,
 		Errors:               make([]*api.Error, 0, len(orig.Errors)),
 		Links:                make([]api.Link, 0)}
 
@@ -33,6 +50,21 @@ func ToAPIRequest(orig *Request) *api.Request {
 		r.Metadata = ToAPIMetadata(orig.Metadata)
 	}
 
+	if orig.SealedSecrets != "" || orig.Secrets != nil {
+		r.Secrets = api.RedactedValue
+	}
+
+	if len(orig.Checksums) > 0 {
+		r.ExpectedChecksums = ToAPIChecksums(orig.ExpectedChecksums())
+	}
+
+	if len(orig.RedirectChain) > 0 {
+		r.RedirectChain = make([]api.Redirect, len(orig.RedirectChain))
+		for i, rd := range orig.RedirectChain {
+			r.RedirectChain[i] = ToAPIRedirect(rd)
+		}
+	}
+
 	if len(orig.Errors) > 0 {
 		for _, e := range orig.Errors {
 			if e.OriginalError != "" {
@@ -44,16 +76,94 @@ func ToAPIRequest(orig *Request) *api.Request {
 	return r
 }
 
-// FromAPIIncomingRequest ...
-func FromAPIIncomingRequest(air *api.IncomingRequest) *Request {
+// ToAPIChecksums ...
+func ToAPIChecksums(checksums []Checksum) []api.Checksum {
+	cs := make([]api.Checksum, len(checksums))
+	for i, c := range checksums {
+		cs[i] = api.Checksum{Type: c.Type, Value: c.Value}
+	}
+	return cs
+}
+
+// ToAPIRedirect ...
+func ToAPIRedirect(rd Redirect) api.Redirect {
+	return api.Redirect{
+		URL:        rd.URL,
+		StatusCode: rd.StatusCode,
+		Location:   rd.Location}
+}
+
+// FromAPIIncomingRequest returns an error if a checksum or the integrity
+// value can't be normalized.
+func FromAPIIncomingRequest(air *api.IncomingRequest) (*Request, error) {
 	downloadReq := &Request{
-		URL:          air.URL,
-		Checksum:     air.Checksum,
-		ChecksumType: air.ChecksumType,
-		Callback:     air.Callback,
-		Errors:       make([]*RequestError, 0)}
+		URL:         air.URL,
+		ChecksumURL: air.ChecksumURL,
+		Callback:    air.Callback,
+		Priority:    air.Priority,
+		NotBefore:   air.NotBefore,
+		Deadline:    air.Deadline,
+		Labels:      air.Labels,
+		Errors:      make([]*RequestError, 0)}
 
-	return downloadReq
+	var err error
+	if air.Checksum != "" {
+		downloadReq.Checksum, downloadReq.ChecksumType, err = NormalizeChecksum(air.Checksum, air.ChecksumType)
+		if err != nil {
+			return nil, err
+		}
+	} else if air.ChecksumType != "" {
+		downloadReq.ChecksumType = NormalizeChecksumType(air.ChecksumType)
+	}
+
+	for _, c := range air.Checksums {
+		value, checksumType, err := NormalizeChecksum(c.Value, c.Type)
+		if err != nil {
+			return nil, err
+		}
+		downloadReq.Checksums = append(downloadReq.Checksums, Checksum{
+			Type:  checksumType,
+			Value: value})
+	}
+
+	if air.Integrity != "" {
+		integrity, err := ParseIntegrity(air.Integrity)
+		if err != nil {
+			return nil, err
+		}
+		downloadReq.Checksums = append(downloadReq.Checksums, integrity...)
+	}
+
+	for k, v := range air.Headers {
+		if IsSensitiveHeader(k) {
+			downloadReq.secrets().Headers[http.CanonicalHeaderKey(k)] = v
+		} else {
+			if downloadReq.Headers == nil {
+				downloadReq.Headers = make(map[string]string)
+			}
+			downloadReq.Headers[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+
+	if air.Credentials != nil {
+		downloadReq.secrets().Credentials = &Credentials{
+			Username: air.Credentials.Username,
+			Password: air.Credentials.Password,
+			Token:    air.Credentials.Token}
+	}
+
+	return downloadReq, nil
+}
+
+// HashIncomingRequest returns a digest of the decoded request so that
+// equivalent bodies compare equal regardless of formatting. The body holds
+// credentials, so the digest is keyed with the box rather than a plain hash.
+func HashIncomingRequest(box *SecretBox, air *api.IncomingRequest) string {
+	jsonBytes, err := json.Marshal(air)
+	if err != nil {
+		return ""
+	}
+	return box.Sum(jsonBytes)
 }
 
 // ToAPIError ...
@@ -67,3 +177,8 @@ func ToAPIError(e *common.TimestampedError) *api.Error {
 
 	return err
 }
+
+// ToAPIPolicyError ...
+func ToAPIPolicyError(e *PolicyError, errorTime time.Time) *api.Error {
+	return &api.Error{Time: errorTime, Error: e.Message, Code: e.Code}
+}
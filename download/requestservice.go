@@ -1,75 +1,723 @@
 package download
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/tracing"
 )
 
 // RequestService ...
 type RequestService struct {
 	Clock          common.Clock
 	IDGenerator    IDGenerator
+	MetadataClient *MetadataClient
+	SecretBox      *SecretBox
+	PolicyFile     *PolicyFile
+	Tenants        *Tenants
+	Quotas         *QuotaTracker
+	// HostLimiter limits concurrent calls to the download agent for each
+	// origin host. A host's slot is released once the agent has accepted
+	// the request, not when it has finished downloading.
+	HostLimiter    *HostLimiter
 	requestStore   RequestStore
 	downloadClient Client
+	queue          *DispatchQueue
+	workers        sync.WaitGroup
+	dispatchCtx    context.Context
+	stopDispatch   context.CancelFunc
+	idempotency    sync.Mutex
+	inFlightKeys   map[string]bool
+	refreshes      keyedMutex
+	updates        keyedMutex
 }
 
 // NewRequestService ...
-func NewRequestService(requestStore RequestStore, downloadClient Client) *RequestService {
+func NewRequestService(requestStore RequestStore, downloadClient Client, secretBox *SecretBox) *RequestService {
 	s := RequestService{
 		IDGenerator:    &UUIDGenerator{},
 		Clock:          &common.RealClock{},
+		MetadataClient: NewMetadataClient(NewRedirectPolicy(), NewAddressGuard()),
+		SecretBox:      secretBox,
+		HostLimiter:    NewHostLimiter(0, 0),
 		requestStore:   requestStore,
-		downloadClient: downloadClient}
+		downloadClient: downloadClient,
+		queue:          NewDispatchQueue(),
+		inFlightKeys:   make(map[string]bool)}
+
+	s.MetadataClient.Policies = s.Policy
+
+	s.Quotas = NewQuotaTracker()
+	s.Quotas.TenantQuotas = func(tenant string) *Quota {
+		return s.Tenants.Quota(tenant)
+	}
 
 	return &s
 }
 
+// Start runs dispatchers that send queued requests to the download client.
+func (s *RequestService) Start(dispatchers int) {
+	s.dispatchCtx, s.stopDispatch = context.WithCancel(context.Background())
+	for i := 0; i < dispatchers; i++ {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for {
+				downloadRequest, ok := s.queue.NextReady(s.HostLimiter)
+				if !ok {
+					return
+				}
+				// the re-probe of a deferred request may resolve it to
+				// another host, so release the one NextReady started
+				host := downloadRequest.Host()
+
+				// dispatches continue the trace of the call that queued them
+				ctx := tracing.ContextWithTraceparent(s.dispatchCtx, downloadRequest.TraceParent)
+				s.dispatch(ctx, downloadRequest)
+				s.HostLimiter.Release(host)
+				if downloadRequest.State == RequestScheduled {
+					// the origin deferred it again
+					s.queue.Push(downloadRequest)
+				} else {
+					s.Quotas.Release(downloadRequest)
+				}
+				s.queue.Wake()
+
+				// an interrupted dispatch is still checkpointed
+				_, err := s.update(context.WithoutCancel(ctx), downloadRequest, func(r *Request) {
+					r.State = downloadRequest.State
+					r.Errors = downloadRequest.Errors
+					r.DownloadID = downloadRequest.DownloadID
+					r.NotBefore = downloadRequest.NotBefore
+					r.Metadata = downloadRequest.Metadata
+					r.ResolvedURL = downloadRequest.ResolvedURL
+					r.RedirectChain = downloadRequest.RedirectChain
+					r.Checksum = downloadRequest.Checksum
+					r.ChecksumType = downloadRequest.ChecksumType
+				})
+				if err != nil {
+					Logger(downloadRequest).Error("dispatch-update-error", "error", err)
+				}
+			}
+		}()
+	}
+}
+
+// Stop stops the dispatchers once their current requests are dispatched.
+// If ctx is done first, dispatches still in progress are interrupted and
+// Stop returns ctx's error once their requests have been saved as queued.
+// Requests that are still queued stay queued in the store, to be resumed
+// when the service next starts, so the store may be closed once Stop
+// returns.
+func (s *RequestService) Stop(ctx context.Context) error {
+	s.queue.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.interruptDispatch()
+		<-done
+	}
+	s.interruptDispatch()
+	return err
+}
+
+func (s *RequestService) interruptDispatch() {
+	if s.stopDispatch != nil {
+		s.stopDispatch()
+	}
+}
+
+// interrupted returns true if Stop interrupted the dispatch of
+// downloadRequest, which is left as it was to be resumed on the next start.
+func (s *RequestService) interrupted(downloadRequest *Request, err error) bool {
+	if s.dispatchCtx == nil || s.dispatchCtx.Err() == nil {
+		return false
+	}
+	Logger(downloadRequest).Warn("dispatch-interrupted", "state", downloadRequest.State, "error", err)
+	return true
+}
+
+// Resume queues the requests that were accepted but not dispatched before
+// the service last stopped, and returns how many there were. It should be
+// called before Start. Requests whose secrets can no longer be opened fail.
+func (s *RequestService) Resume(ctx context.Context) (int, error) {
+	requests, err := s.requestStore.FindByStates(ctx, []string{RequestQueued, RequestScheduled})
+	if err != nil {
+		return 0, err
+	}
+
+	resumed := 0
+	for _, downloadRequest := range requests {
+		err = downloadRequest.OpenSecrets(s.SecretBox)
+		if err != nil {
+			downloadRequest.AddError(fmt.Errorf("secrets could not be opened on resume: %v", err), s.Clock.Now())
+			downloadRequest.State = RequestFailed
+			Logger(downloadRequest).Warn("resume-error", "error", err)
+
+			err = s.requestStore.Update(ctx, downloadRequest)
+			if err != nil {
+				return resumed, err
+			}
+			continue
+		}
+
+		s.Quotas.Track(downloadRequest)
+		s.queue.Push(downloadRequest)
+		resumed++
+	}
+	return resumed, nil
+}
+
+// Idempotency errors.
+var (
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was used with a different request body")
+	ErrIdempotencyKeyInUse    = errors.New("a request with this idempotency key is still being processed")
+)
+
+// ErrNotCancellable is returned when cancelling a request that has already
+// been dispatched or has failed.
+var ErrNotCancellable = errors.New("only queued or scheduled requests can be cancelled")
+
+// DefaultRetryAfter is how long a host is deferred when it responds 429 or
+// 503 without a Retry-After header.
+const DefaultRetryAfter = time.Minute
+
+// maxVersions bounds how far a request's version history is followed.
+const maxVersions = 100
+
 // ProcessNewRequest ...
-func (s *RequestService) ProcessNewRequest(downloadRequest *Request) (*Request, error) {
-	id, err := s.IDGenerator.GenerateID()
+func (s *RequestService) ProcessNewRequest(ctx context.Context, downloadRequest *Request) (r *Request, err error) {
+	ctx, span := tracing.Start(ctx, "RequestService.ProcessNewRequest", tracing.KindInternal)
+	defer func() { endSpan(span, err) }()
+
+	err = s.assignID(downloadRequest)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttribute("request.id", downloadRequest.ID)
+	downloadRequest.TraceParent = span.Context().Traceparent()
+
+	// quotas are checked before probing, so clients that have used theirs
+	// can't have the origin probed either
+	err = s.reserve(downloadRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.MetadataClient.GetMetadataFromHead(ctx, s.Clock.Now(), downloadRequest)
+
+	return s.processMetadata(ctx, downloadRequest, m, err)
+}
+
+// ProcessIdempotentRequest processes a request unless one with the same
+// submitter and idempotency key already exists, in which case that request
+// is returned along with true. ErrIdempotencyKeyMismatch is returned if the
+// existing request was made with a different body.
+func (s *RequestService) ProcessIdempotentRequest(ctx context.Context, downloadRequest *Request) (*Request, bool, error) {
+	if downloadRequest.IdempotencyKey == "" {
+		r, err := s.ProcessNewRequest(ctx, downloadRequest)
+		return r, false, err
+	}
+
+	existing, err := s.findIdempotent(ctx, downloadRequest)
+	if err != nil || existing != nil {
+		return existing, existing != nil, err
+	}
+
+	key := downloadRequest.Tenant + "\x00" + downloadRequest.Submitter + "\x00" + downloadRequest.IdempotencyKey
+	s.idempotency.Lock()
+	if s.inFlightKeys[key] {
+		s.idempotency.Unlock()
+		return nil, false, ErrIdempotencyKeyInUse
+	}
+	s.inFlightKeys[key] = true
+	s.idempotency.Unlock()
+
+	defer func() {
+		s.idempotency.Lock()
+		delete(s.inFlightKeys, key)
+		s.idempotency.Unlock()
+	}()
+
+	// another request with the key may have finished before it was claimed
+	existing, err = s.findIdempotent(ctx, downloadRequest)
+	if err != nil || existing != nil {
+		return existing, existing != nil, err
+	}
+
+	r, err := s.ProcessNewRequest(ctx, downloadRequest)
+	return r, false, err
+}
+
+func (s *RequestService) findIdempotent(ctx context.Context, downloadRequest *Request) (*Request, error) {
+	existing, err := s.requestStore.FindByIdempotencyKey(ctx, downloadRequest.Tenant, downloadRequest.Submitter, downloadRequest.IdempotencyKey)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	if existing.BodyHash != downloadRequest.BodyHash {
+		return existing, ErrIdempotencyKeyMismatch
+	}
+	return existing, nil
+}
+
+// Refresh re-probes the latest version of a request with conditional
+// headers and creates a new version only if the resource has changed. It
+// returns the latest version and whether it was created by this call.
+func (s *RequestService) Refresh(ctx context.Context, tenant string, id string, correlationID string) (r *Request, created bool, err error) {
+	ctx, span := tracing.Start(ctx, "RequestService.Refresh", tracing.KindInternal)
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("request.id", id)
+
+	latest, unlock, err := s.lockLatestVersion(ctx, tenant, id)
+	if err != nil || latest == nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	err = latest.OpenSecrets(s.SecretBox)
+	if err != nil {
+		return nil, false, err
+	}
+
+	next := latest.NewVersion()
+	next.CorrelationID = correlationID
+	next.TraceParent = span.Context().Traceparent()
+	err = s.reserve(next)
+	if err != nil {
+		return nil, false, err
+	}
+
+	m, err := s.MetadataClient.GetConditionalMetadata(ctx, s.Clock.Now(), next, latest.Metadata)
+	if err != nil {
+		s.Quotas.Release(next)
+		return nil, false, err
+	} else if m.Unchanged(latest.Metadata) {
+		s.Quotas.Release(next)
+		return latest, false, nil
+	}
+
+	err = s.assignID(next)
 	if err != nil {
+		return nil, false, err
+	}
+
+	next, err = s.processMetadata(ctx, next, m, nil)
+	if err != nil {
+		return next, false, err
+	}
+
+	_, err = s.update(ctx, latest, func(r *Request) {
+		r.NextRequestID = next.ID
+	})
+
+	return next, true, err
+}
+
+// History returns every version of the request with the given id, oldest
+// first.
+func (s *RequestService) History(ctx context.Context, tenant string, id string) ([]*Request, error) {
+	r, err := s.requestStore.FindByID(ctx, tenant, id)
+	if err != nil || r == nil {
 		return nil, err
 	}
 
+	for i := 0; i < maxVersions && r.PreviousRequestID != ""; i++ {
+		previous, err := s.requestStore.FindByID(ctx, tenant, r.PreviousRequestID)
+		if err != nil {
+			return nil, err
+		} else if previous == nil {
+			break
+		}
+		r = previous
+	}
+
+	history := []*Request{r}
+	for i := 0; i < maxVersions && r.NextRequestID != ""; i++ {
+		r, err = s.requestStore.FindByID(ctx, tenant, r.NextRequestID)
+		if err != nil {
+			return nil, err
+		} else if r == nil {
+			break
+		}
+		history = append(history, r)
+	}
+
+	return history, nil
+}
+
+// lockLatestVersion finds the latest version of a request and locks it so
+// that only one refresh at a time can add the version after it. The
+// returned func unlocks it.
+func (s *RequestService) lockLatestVersion(ctx context.Context, tenant string, id string) (*Request, func(), error) {
+	for i := 0; i < maxVersions; i++ {
+		latest, err := s.latestVersion(ctx, tenant, id)
+		if err != nil || latest == nil {
+			return nil, nil, err
+		}
+
+		unlock := s.refreshes.Lock(tenant + "\x00" + latest.ID)
+		// a refresh holding the lock may have added a version meanwhile
+		current, err := s.latestVersion(ctx, tenant, latest.ID)
+		if err == nil && current != nil && current.ID == latest.ID {
+			return current, unlock, nil
+		}
+		unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+		id = latest.ID
+	}
+	return nil, nil, fmt.Errorf("request %s has more than %d versions", id, maxVersions)
+}
+
+func (s *RequestService) latestVersion(ctx context.Context, tenant string, id string) (*Request, error) {
+	r, err := s.requestStore.FindByID(ctx, tenant, id)
+	for i := 0; i < maxVersions && err == nil && r != nil && r.NextRequestID != ""; i++ {
+		var next *Request
+		next, err = s.requestStore.FindByID(ctx, tenant, r.NextRequestID)
+		if next == nil {
+			break
+		}
+		r = next
+	}
+	return r, err
+}
+
+func (s *RequestService) assignID(downloadRequest *Request) error {
+	id, err := s.IDGenerator.GenerateID()
+	if err != nil {
+		return err
+	}
+
 	downloadRequest.ID = id
 	downloadRequest.TimeRequested = s.Clock.Now()
+	return nil
+}
 
-	m, err := GetMetadataFromHead(s.Clock.Now(), downloadRequest)
+// reserve counts a request against its quotas before it is probed.
+func (s *RequestService) reserve(downloadRequest *Request) error {
+	err := s.Quotas.Reserve(downloadRequest)
 	if err != nil {
+		requestsTotal.With(OutcomeQuotaRejected).Inc()
+	}
+	return err
+}
+
+// processMetadata records the result of probing a request, adds it to the
+// store and queues it for dispatch if it can be downloaded. Policy violations
+// and exceeded quotas are returned without storing the request. The request
+// must have been reserved, and stays counted as queued only if it is.
+func (s *RequestService) processMetadata(ctx context.Context, downloadRequest *Request, m *Metadata, probeErr error) (*Request, error) {
+	err := probeErr
+	var deferredErr *HostDeferredError
+	if errors.As(err, &deferredErr) {
+		// the host asked to be left alone, so probe it once it is ready
+		err = nil
+		if deferredErr.Until.After(downloadRequest.NotBefore) {
+			downloadRequest.NotBefore = deferredErr.Until
+		}
+		Logger(downloadRequest).Info("request-deferred", "host", deferredErr.Host, "not_before", deferredErr.Until)
+	} else if err == nil {
+		err = s.checkMetadata(ctx, downloadRequest, m)
+	}
+
+	if policyErr, ok := err.(*PolicyError); ok {
+		s.Quotas.Release(downloadRequest)
+		requestsTotal.With(OutcomePolicyRejected).Inc()
+		return nil, policyErr
+	} else if err != nil {
 		downloadRequest.AddError(err, s.Clock.Now())
+		downloadRequest.State = RequestFailed
+	} else if downloadRequest.NotBefore.After(s.Clock.Now()) {
+		downloadRequest.State = RequestScheduled
 	} else {
-		downloadRequest.Metadata = m
-		if m.StatusCode == http.StatusOK {
-			download, err := s.downloadClient.ProcessRequest(downloadRequest)
-			if err != nil {
-				downloadRequest.AddError(err, s.Clock.Now())
-			}
-			if download != nil {
-				downloadRequest.DownloadID = download.ID
-			}
-		} else {
-			err := fmt.Errorf("non-200 response from source")
+		downloadRequest.State = RequestQueued
+	}
+
+	// the size is counted once the origin has reported it, which may only
+	// be when a deferred request is probed again
+	queued := downloadRequest.State == RequestQueued || downloadRequest.State == RequestScheduled
+	if !queued {
+		s.Quotas.Release(downloadRequest)
+	} else if m := downloadRequest.Metadata; m != nil && !m.Deferred() {
+		err = s.Quotas.ReserveBytes(downloadRequest)
+		if err != nil {
+			s.Quotas.Release(downloadRequest)
+			requestsTotal.With(OutcomeQuotaRejected).Inc()
+			return nil, err
+		}
+	}
+
+	err = downloadRequest.SealSecrets(s.SecretBox)
+	if err != nil {
+		if queued {
+			s.Quotas.Release(downloadRequest)
+		}
+		return nil, err
+	}
+
+	err = s.requestStore.Add(ctx, downloadRequest)
+	if err != nil {
+		if queued {
+			s.Quotas.Release(downloadRequest)
+		}
+		downloadRequest.AddError(err, s.Clock.Now())
+		return downloadRequest, err
+	}
 
+	if queued {
+		// the dispatcher changes its own copy, not the one returned
+		s.queue.Push(downloadRequest.Copy())
+	}
+	requestsTotal.With(downloadRequest.State).Inc()
+	Logger(downloadRequest).Info("request-added", "url", downloadRequest.URL, "state", downloadRequest.State)
+
+	return downloadRequest, nil
+}
+
+// checkMetadata applies the policy to a request's metadata and fetches its
+// checksum if needed, so that it is ready to be dispatched. If the origin
+// deferred the probe, the request's NotBefore is moved to when the origin
+// asked to be retried; it is probed again before it is dispatched.
+func (s *RequestService) checkMetadata(ctx context.Context, downloadRequest *Request, m *Metadata) error {
+	downloadRequest.Metadata = m
+
+	if m.Deferred() {
+		retryAfter := m.RetryAfter
+		if retryAfter.IsZero() {
+			retryAfter = s.Clock.Now().Add(DefaultRetryAfter)
+		}
+		s.deferHost(downloadRequest.Host(), retryAfter)
+		if retryAfter.After(downloadRequest.NotBefore) {
+			downloadRequest.NotBefore = retryAfter
+		}
+		Logger(downloadRequest).Info("request-deferred", "status", m.StatusCode, "not_before", retryAfter)
+		return nil
+	}
+
+	policy := s.Policy(downloadRequest.Tenant)
+	if policy != nil {
+		err := policy.CheckMetadata(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-200 response from source")
+	}
+
+	if downloadRequest.ChecksumURL != "" && downloadRequest.Checksum == "" {
+		err := s.MetadataClient.GetChecksumFromURL(ctx, downloadRequest)
+		if err != nil {
+			return fmt.Errorf("checksum url: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *RequestService) dispatch(ctx context.Context, downloadRequest *Request) {
+	ctx, span := startSpan(ctx, "RequestService.dispatch", tracing.KindInternal, downloadRequest)
+	defer span.End()
+
+	if downloadRequest.DeadlinePassed(s.Clock.Now()) {
+		err := fmt.Errorf("deadline %v passed before the request could be dispatched", downloadRequest.Deadline)
+		downloadRequest.AddError(err, s.Clock.Now())
+		downloadRequest.State = RequestFailed
+		requestsTotal.With(OutcomeExpired).Inc()
+		Logger(downloadRequest).Warn("dispatch-expired", "deadline", downloadRequest.Deadline)
+		return
+	}
+
+	// requests the origin or its host deferred are probed again first
+	if downloadRequest.Metadata == nil || downloadRequest.Metadata.Deferred() {
+		m, err := s.MetadataClient.GetMetadataFromHead(ctx, s.Clock.Now(), downloadRequest)
+		var deferredErr *HostDeferredError
+		if errors.As(err, &deferredErr) {
+			downloadRequest.NotBefore = deferredErr.Until
+			downloadRequest.State = RequestScheduled
+			return
+		} else if err == nil {
+			err = s.checkMetadata(ctx, downloadRequest, m)
+		}
+		if err == nil && m.Deferred() {
+			downloadRequest.State = RequestScheduled
+			return
+		} else if err == nil {
+			err = s.Quotas.ReserveBytes(downloadRequest)
+		}
+		if err != nil && s.interrupted(downloadRequest, err) {
+			return
+		} else if err != nil {
 			downloadRequest.AddError(err, s.Clock.Now())
+			downloadRequest.State = RequestFailed
+			requestsTotal.With(OutcomeDispatchFailed).Inc()
+			Logger(downloadRequest).Warn("dispatch-probe-error", "error", err)
+			return
 		}
 	}
 
-	err = s.requestStore.Add(downloadRequest)
+	if t := s.Tenants.Get(downloadRequest.Tenant); t != nil {
+		downloadRequest.CallbackSecret = t.CallbackSecret
+	}
+
+	dispatches := inFlight.With("dispatch")
+	dispatches.Inc()
+	start := time.Now()
+	download, err := s.downloadClient.ProcessRequest(ctx, downloadRequest)
+	dispatches.Dec()
+
+	if err != nil && s.interrupted(downloadRequest, err) {
+		span.SetError(err)
+		return
+	}
+
+	outcome := OutcomeDispatched
 	if err != nil {
 		downloadRequest.AddError(err, s.Clock.Now())
+		downloadRequest.State = RequestFailed
+		outcome = OutcomeDispatchFailed
+		span.SetError(err)
+		Logger(downloadRequest).Error("dispatch-error", "error", err)
+	} else {
+		downloadRequest.State = RequestDispatched
+		Logger(downloadRequest).Info("request-dispatched")
+	}
+	dispatchDuration.With(outcome).ObserveSince(start)
+	requestsTotal.With(outcome).Inc()
+	if download != nil {
+		downloadRequest.DownloadID = download.ID
+	}
+}
+
+// Cancel removes a queued or scheduled request from the dispatch queue.
+func (s *RequestService) Cancel(ctx context.Context, tenant string, id string) (*Request, error) {
+	downloadRequest, err := s.requestStore.FindByID(ctx, tenant, id)
+	if err != nil || downloadRequest == nil {
+		return nil, err
+	}
+
+	if downloadRequest.State == RequestCancelled {
+		return downloadRequest, nil
+	}
+
+	// a request a dispatcher has taken from the queue can't be removed
+	if !s.queue.Remove(id) {
+		return downloadRequest, ErrNotCancellable
 	}
 
-	return downloadRequest, err
+	s.Quotas.Release(downloadRequest)
+	requestsTotal.With(OutcomeCancelled).Inc()
+	return s.update(ctx, downloadRequest, func(r *Request) {
+		r.State = RequestCancelled
+	})
+}
+
+// update applies change to the stored copy of r while holding r's lock, so
+// that the changes made by dispatchers, cancellations and refreshes are
+// not lost to each other.
+func (s *RequestService) update(ctx context.Context, r *Request, change func(*Request)) (*Request, error) {
+	unlock := s.updates.Lock(r.Tenant + "\x00" + r.ID)
+	defer unlock()
+
+	current, err := s.requestStore.FindByID(ctx, r.Tenant, r.ID)
+	if err != nil {
+		return nil, err
+	} else if current == nil {
+		return nil, fmt.Errorf("unable to find request with id:%s", r.ID)
+	}
+
+	change(current)
+	return current, s.requestStore.Update(ctx, current)
+}
+
+// deferHost holds back probes and queued dispatches for host.
+func (s *RequestService) deferHost(host string, until time.Time) {
+	s.MetadataClient.HostLimiter.Defer(host, until)
+	s.HostLimiter.Defer(host, until)
+	s.queue.Wake()
+}
+
+// QueueDepth ...
+func (s *RequestService) QueueDepth() int {
+	return s.queue.Len()
+}
+
+// QueuePosition returns the request's 1-based position in the dispatch
+// queue, or 0 if it is not queued.
+func (s *RequestService) QueuePosition(id string) int {
+	return s.queue.Position(id)
+}
+
+// CheckURL returns an error if the url is rejected by the tenant's policy or
+// its host resolves to an address the metadata client would refuse to
+// connect to.
+func (s *RequestService) CheckURL(tenant string, u *url.URL) error {
+	policy := s.Policy(tenant)
+	if policy != nil {
+		err := policy.CheckURL(u)
+		if err != nil {
+			return err
+		}
+	}
+	return s.MetadataClient.CheckHost(u.Hostname())
+}
+
+// Policy returns the tenant's own policy, falling back to the instance's.
+func (s *RequestService) Policy(tenant string) *Policy {
+	if p := s.Tenants.Get(tenant).Policy(); p != nil {
+		return p
+	}
+	if s.PolicyFile == nil {
+		return nil
+	}
+	return s.PolicyFile.Policy()
 }
 
 // ListAll ...
-func (s *RequestService) ListAll() ([]*Request, error) {
-	return s.requestStore.FindAll(0, 100)
+func (s *RequestService) ListAll(ctx context.Context, tenant string) ([]*Request, error) {
+	return s.requestStore.FindAll(ctx, tenant, 0, 100)
+}
+
+// ListForPrincipal lists every request in the principal's tenant for admins
+// and otherwise only the principal's own requests.
+func (s *RequestService) ListForPrincipal(ctx context.Context, p *Principal) ([]*Request, error) {
+	if p == nil {
+		return s.ListAll(ctx, DefaultTenant)
+	} else if p.IsAdmin() {
+		return s.ListAll(ctx, p.Tenant)
+	}
+	return s.requestStore.FindByOwner(ctx, p.Tenant, p.ID, 0, 100)
+}
+
+// FindByLabelsForPrincipal finds the requests matching selector that
+// ListForPrincipal would list.
+func (s *RequestService) FindByLabelsForPrincipal(ctx context.Context, p *Principal, selector LabelSelector) ([]*Request, error) {
+	if p == nil {
+		return s.requestStore.FindByLabels(ctx, DefaultTenant, "", selector, 0, 100)
+	} else if p.IsAdmin() {
+		return s.requestStore.FindByLabels(ctx, p.Tenant, "", selector, 0, 100)
+	}
+	return s.requestStore.FindByLabels(ctx, p.Tenant, p.ID, selector, 0, 100)
 }
 
 // FindByID ...
-func (s *RequestService) FindByID(id string) (*Request, error) {
-	return s.requestStore.FindByID(id)
+func (s *RequestService) FindByID(ctx context.Context, tenant string, id string) (*Request, error) {
+	return s.requestStore.FindByID(ctx, tenant, id)
 }
@@ -0,0 +1,328 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resumeStore holds the requests left behind by a previous run.
+type resumeStore struct {
+	RequestStore
+	requests []*Request
+	updated  []*Request
+}
+
+func (s *resumeStore) FindByStates(ctx context.Context, states []string) ([]*Request, error) {
+	return s.requests, nil
+}
+
+func (s *resumeStore) Update(ctx context.Context, r *Request) error {
+	s.updated = append(s.updated, r)
+	return nil
+}
+
+func TestResumeQueuesUnfinishedRequests(t *testing.T) {
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBox, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := &Request{ID: "sealed", Submitter: "client", State: RequestQueued, Secrets: &Secrets{Headers: map[string]string{"cookie": "a"}}}
+	err = sealed.SealSecrets(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed.Secrets = nil
+
+	lost := &Request{ID: "lost", Submitter: "client", State: RequestQueued, Secrets: &Secrets{}}
+	err = lost.SealSecrets(otherBox)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &resumeStore{requests: []*Request{
+		sealed,
+		{ID: "scheduled", Submitter: "client", State: RequestScheduled},
+		lost}}
+	s := NewRequestService(store, nil, box)
+
+	resumed, err := s.Resume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed != 2 || s.QueueDepth() != 2 {
+		t.Errorf("expected 2 requests resumed, got %d with %d queued", resumed, s.QueueDepth())
+	}
+	if sealed.Secrets == nil {
+		t.Errorf("expected the resumed request's secrets to be opened")
+	}
+	if len(store.updated) != 1 || lost.State != RequestFailed {
+		t.Errorf("expected the request whose secrets can't be opened to fail, got %s", lost.State)
+	}
+
+	client, _ := s.Quotas.Usage(DefaultTenant, "client")
+	if client.Queued != 2 {
+		t.Errorf("expected resumed requests to count as queued, got %d", client.Queued)
+	}
+}
+
+// memoryStore keeps copies of requests, as a database would.
+type memoryStore struct {
+	RequestStore
+	sync.Mutex
+	requests map[string]Request
+}
+
+func (s *memoryStore) Add(ctx context.Context, r *Request) error {
+	s.Lock()
+	defer s.Unlock()
+	s.requests[r.ID] = *r
+	return nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, r *Request) error {
+	return s.Add(ctx, r)
+}
+
+func (s *memoryStore) FindByID(ctx context.Context, tenant string, id string) (*Request, error) {
+	s.Lock()
+	defer s.Unlock()
+	r, ok := s.requests[id]
+	if !ok || r.Tenant != tenant {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func TestConcurrentRefreshesKeepOneHistory(t *testing.T) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// every probe sees a changed resource
+		rw.Header().Set("ETag", fmt.Sprintf("\"v%d\"", atomic.AddInt32(&probes, 1)))
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{requests: map[string]Request{
+		"first": {ID: "first", URL: server.URL, State: RequestDispatched, Metadata: &Metadata{ETag: "\"v0\""}}}}
+	s := NewRequestService(store, nil, box)
+	s.MetadataClient = NewMetadataClient(NewRedirectPolicy(), nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.Refresh(context.Background(), DefaultTenant, "first", "")
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	history, err := s.History(context.Background(), DefaultTenant, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(store.requests) {
+		t.Errorf("expected every version in the history, got %d of %d", len(history), len(store.requests))
+	}
+}
+
+func TestDeferredProbeSchedulesRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "120")
+		rw.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{requests: map[string]Request{}}
+	s := NewRequestService(store, nil, box)
+	s.MetadataClient = NewMetadataClient(NewRedirectPolicy(), nil)
+
+	r, err := s.ProcessNewRequest(context.Background(), &Request{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.State != RequestScheduled || r.NotBefore.Before(time.Now().Add(time.Minute)) {
+		t.Errorf("expected the request to be scheduled after Retry-After, got %s at %v", r.State, r.NotBefore)
+	}
+	if s.QueueDepth() != 1 {
+		t.Errorf("expected the request to be queued, got %d queued", s.QueueDepth())
+	}
+}
+
+// blockingClient doesn't answer until the dispatch is interrupted.
+type blockingClient struct {
+	started     chan bool
+	interrupted int32
+}
+
+func (c *blockingClient) ProcessRequest(ctx context.Context, r *Request) (*Download, error) {
+	c.started <- true
+	<-ctx.Done()
+	atomic.StoreInt32(&c.interrupted, 1)
+	return nil, ctx.Err()
+}
+
+func TestStopCheckpointsInterruptedDispatches(t *testing.T) {
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{requests: map[string]Request{}}
+	client := &blockingClient{started: make(chan bool, 1)}
+	s := NewRequestService(store, client, box)
+
+	r := &Request{ID: "r", URL: "http://example.com/", State: RequestQueued, Metadata: &Metadata{StatusCode: http.StatusOK}}
+	err = store.Add(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.queue.Push(r.Copy())
+	s.Start(1)
+	<-client.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = s.Stop(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected Stop to time out, got %v", err)
+	}
+	if atomic.LoadInt32(&client.interrupted) == 0 {
+		t.Errorf("expected Stop to interrupt the dispatch before returning")
+	}
+
+	stored, _ := store.FindByID(context.Background(), DefaultTenant, "r")
+	if stored.State != RequestQueued || len(stored.Errors) != 0 {
+		t.Errorf("expected the interrupted request to stay queued, got %s with errors %v", stored.State, stored.Errors)
+	}
+}
+
+// acceptingClient accepts every request, reporting each one.
+type acceptingClient struct {
+	dispatched chan *Request
+}
+
+func (c *acceptingClient) ProcessRequest(ctx context.Context, r *Request) (*Download, error) {
+	c.dispatched <- r
+	return &Download{ID: "download-" + r.ID}, nil
+}
+
+func TestDispatchReleasesTheHostItStarted(t *testing.T) {
+	final := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer final.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, final.URL+"/file", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{requests: map[string]Request{}}
+	client := &acceptingClient{dispatched: make(chan *Request, 1)}
+	s := NewRequestService(store, client, box)
+	s.MetadataClient = NewMetadataClient(NewRedirectPolicy(), nil)
+	s.HostLimiter = NewHostLimiter(1, 0)
+
+	// deferred when it was accepted, so it is probed again on dispatch
+	r := &Request{ID: "r", URL: origin.URL + "/file", State: RequestScheduled,
+		Metadata: &Metadata{StatusCode: http.StatusTooManyRequests}}
+	err = store.Add(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.queue.Push(r.Copy())
+	s.Start(1)
+	<-client.dispatched
+
+	err = s.Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originHost := r.Host()
+	if ready, _ := s.HostLimiter.Ready(originHost); !ready {
+		t.Errorf("expected %s to be released after the dispatch", originHost)
+	}
+}
+
+func TestSubmissionToDeferredHostIsScheduled(t *testing.T) {
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStore{requests: map[string]Request{}}
+	s := NewRequestService(store, nil, box)
+	s.MetadataClient = NewMetadataClient(NewRedirectPolicy(), nil)
+	s.MetadataClient.MaxHostWait = 0
+
+	until := time.Now().Add(time.Hour)
+	s.deferHost("example.com", until)
+
+	r, err := s.ProcessNewRequest(context.Background(), &Request{URL: "http://example.com/file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.State != RequestScheduled || !r.NotBefore.Equal(until) || len(r.Errors) != 0 {
+		t.Errorf("expected the request to be scheduled for %v, got %s at %v with %v", until, r.State, r.NotBefore, r.Errors)
+	}
+}
+
+func TestQuotaCheckedBeforeProbing(t *testing.T) {
+	var probes int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	box, err := NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRequestService(&memoryStore{requests: map[string]Request{}}, nil, box)
+	s.MetadataClient = NewMetadataClient(NewRedirectPolicy(), nil)
+	s.Quotas.ClientQuota = &Quota{MaxRequestsPerDay: 1}
+
+	r, err := s.ProcessNewRequest(context.Background(), &Request{URL: server.URL, Submitter: "client"})
+	if err != nil || r.State != RequestFailed {
+		t.Fatalf("expected the first request to fail its probe, got %v %v", r, err)
+	}
+
+	_, err = s.ProcessNewRequest(context.Background(), &Request{URL: server.URL, Submitter: "client"})
+	if _, ok := err.(*QuotaError); !ok {
+		t.Errorf("expected the failed probe to count against the quota, got %v", err)
+	}
+	if probes != 1 {
+		t.Errorf("expected the request over quota not to be probed, got %d probes", probes)
+	}
+
+	client, _ := s.Quotas.Usage(DefaultTenant, "client")
+	if client.Queued != 0 {
+		t.Errorf("expected nothing to be counted as queued, got %d", client.Queued)
+	}
+}
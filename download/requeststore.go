@@ -1,8 +1,19 @@
 package download
 
+import "context"
+
+// RequestStore queries are all scoped to the tenant given after the
+// context, except FindByStates, which finds requests to resume at startup.
+// FindByLabels is also scoped to the owner given after the tenant, unless
+// it is empty.
 type RequestStore interface {
-	Add(*Request) error
-	FindByID(string) (*Request, error)
-	FindByResourceKey(ResourceKey, uint, uint) ([]*Request, error)
-	FindAll(uint, uint) ([]*Request, error)
+	Add(context.Context, *Request) error
+	Update(context.Context, *Request) error
+	FindByID(context.Context, string, string) (*Request, error)
+	FindByResourceKey(context.Context, string, ResourceKey, uint, uint) ([]*Request, error)
+	FindByIdempotencyKey(context.Context, string, string, string) (*Request, error)
+	FindByLabels(context.Context, string, string, LabelSelector, uint, uint) ([]*Request, error)
+	FindByOwner(context.Context, string, string, uint, uint) ([]*Request, error)
+	FindAll(context.Context, string, uint, uint) ([]*Request, error)
+	FindByStates(context.Context, []string) ([]*Request, error)
 }
@@ -0,0 +1,76 @@
+package download
+
+import (
+	"errors"
+	"time"
+)
+
+// Schedule creates requests for a URL on a cron expression or a fixed
+// interval. The requests it creates belong to its tenant and owner, and
+// are charged to the quota of the client that created it.
+type Schedule struct {
+	ID            string
+	Tenant        string
+	Owner         string
+	Submitter     string
+	URL           string
+	Cron          string
+	Interval      time.Duration
+	ChecksumURL   string
+	ChecksumType  string
+	Callback      string
+	TimeCreated   time.Time
+	LastRun       time.Time
+	NextRun       time.Time
+	LastRequestID string
+	Errors        []*RequestError
+}
+
+// Copy returns a copy of the schedule that can be changed without
+// affecting it.
+func (s *Schedule) Copy() *Schedule {
+	c := *s
+	c.Errors = append(make([]*RequestError, 0, len(s.Errors)), s.Errors...)
+	return &c
+}
+
+// NextRunAfter ...
+func (s *Schedule) NextRunAfter(t time.Time) (time.Time, error) {
+	if s.Cron != "" {
+		c, err := ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return c.Next(t), nil
+	}
+
+	if s.Interval > 0 {
+		return t.Add(s.Interval), nil
+	}
+
+	return time.Time{}, errors.New("schedule has neither cron nor interval")
+}
+
+// NewRequest ...
+func (s *Schedule) NewRequest() *Request {
+	submitter := s.Submitter
+	if submitter == "" {
+		// created before schedules recorded their submitter
+		submitter = "schedule:" + s.ID
+	}
+
+	return &Request{
+		URL:          s.URL,
+		ChecksumURL:  s.ChecksumURL,
+		ChecksumType: s.ChecksumType,
+		Callback:     s.Callback,
+		Tenant:       s.Tenant,
+		Owner:        s.Owner,
+		Submitter:    submitter,
+		Errors:       make([]*RequestError, 0)}
+}
+
+// AddError ...
+func (s *Schedule) AddError(scheduleError error, errorTime time.Time) {
+	s.Errors = append(s.Errors, NewRequestError(scheduleError, errorTime))
+}
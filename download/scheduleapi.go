@@ -0,0 +1,70 @@
+package download
+
+import (
+	"time"
+
+	"github.com/patdowney/downloaderd-request/api"
+)
+
+// ToAPIScheduleList ...
+func ToAPIScheduleList(origList *[]*Schedule) *[]*api.Schedule {
+	ss := make([]*api.Schedule, len(*origList))
+
+	for i, s := range *origList {
+		ss[i] = ToAPISchedule(s)
+	}
+
+	return &ss
+}
+
+// ToAPISchedule ...
+func ToAPISchedule(orig *Schedule) *api.Schedule {
+	s := &api.Schedule{
+		ID:            orig.ID,
+		URL:           orig.URL,
+		Cron:          orig.Cron,
+		ChecksumURL:   orig.ChecksumURL,
+		ChecksumType:  orig.ChecksumType,
+		Callback:      orig.Callback,
+		TimeCreated:   orig.TimeCreated,
+		LastRun:       orig.LastRun,
+		NextRun:       orig.NextRun,
+		LastRequestID: orig.LastRequestID,
+		Errors:        make([]*api.Error, 0, len(orig.Errors)),
+		Links:         make([]api.Link, 0)}
+
+	if orig.Interval > 0 {
+		s.Interval = orig.Interval.String()
+	}
+
+	for _, e := range orig.Errors {
+		if e.OriginalError != "" {
+			s.Errors = append(s.Errors, ToAPIError(&e.TimestampedError))
+		}
+	}
+
+	return s
+}
+
+// FromAPIIncomingSchedule ...
+func FromAPIIncomingSchedule(ais *api.IncomingSchedule) (*Schedule, error) {
+	s := &Schedule{
+		URL:         ais.URL,
+		Cron:        ais.Cron,
+		ChecksumURL: ais.ChecksumURL,
+		Callback:    ais.Callback}
+
+	if ais.ChecksumType != "" {
+		s.ChecksumType = NormalizeChecksumType(ais.ChecksumType)
+	}
+
+	if ais.Interval != "" {
+		interval, err := time.ParseDuration(ais.Interval)
+		if err != nil {
+			return nil, err
+		}
+		s.Interval = interval
+	}
+
+	return s, nil
+}
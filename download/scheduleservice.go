@@ -0,0 +1,167 @@
+package download
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/tracing"
+)
+
+// maxScheduleErrors bounds the errors kept on a schedule.
+const maxScheduleErrors = 10
+
+// ScheduleService ...
+type ScheduleService struct {
+	Clock          common.Clock
+	IDGenerator    IDGenerator
+	scheduleStore  ScheduleStore
+	requestService *RequestService
+	stop           chan bool
+	stopped        chan bool
+}
+
+// NewScheduleService ...
+func NewScheduleService(scheduleStore ScheduleStore, requestService *RequestService) *ScheduleService {
+	s := ScheduleService{
+		IDGenerator:    &UUIDGenerator{},
+		Clock:          &common.RealClock{},
+		scheduleStore:  scheduleStore,
+		requestService: requestService}
+
+	return &s
+}
+
+// AddSchedule ...
+func (s *ScheduleService) AddSchedule(schedule *Schedule) (*Schedule, error) {
+	id, err := s.IDGenerator.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.ID = id
+	schedule.TimeCreated = s.Clock.Now()
+	schedule.Errors = make([]*RequestError, 0)
+	schedule.NextRun, err = schedule.NextRunAfter(schedule.TimeCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.scheduleStore.Add(schedule)
+	return schedule, err
+}
+
+// Delete ...
+func (s *ScheduleService) Delete(schedule *Schedule) error {
+	return s.scheduleStore.Delete(schedule)
+}
+
+// ListAll ...
+func (s *ScheduleService) ListAll(tenant string) ([]*Schedule, error) {
+	return s.scheduleStore.FindAll(tenant, 0, 100)
+}
+
+// FindByID ...
+func (s *ScheduleService) FindByID(tenant string, id string) (*Schedule, error) {
+	return s.scheduleStore.FindByID(tenant, id)
+}
+
+// CheckURL checks u against the tenant's policy.
+func (s *ScheduleService) CheckURL(tenant string, u *url.URL) error {
+	return s.requestService.CheckURL(tenant, u)
+}
+
+// RunDue runs every schedule whose next run time has passed.
+func (s *ScheduleService) RunDue() error {
+	now := s.Clock.Now()
+	schedules, err := s.scheduleStore.FindDue(now)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		if !schedule.NextRun.IsZero() && !schedule.NextRun.After(now) {
+			err = s.Run(schedule)
+			if err != nil {
+				slog.Error("schedule-run-error", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Run creates a request for the schedule. After the first run the previous
+// request is refreshed instead, so nothing is downloaded while the origin
+// reports the resource unchanged.
+func (s *ScheduleService) Run(schedule *Schedule) error {
+	var r *Request
+	var err error
+
+	// each run starts a trace of its own
+	ctx, span := tracing.Start(context.Background(), "ScheduleService.Run", tracing.KindInternal)
+	span.SetAttribute("schedule.id", schedule.ID)
+	span.SetAttribute("schedule.tenant", schedule.Tenant)
+	defer span.End()
+
+	if schedule.LastRequestID != "" {
+		r, _, err = s.requestService.Refresh(ctx, schedule.Tenant, schedule.LastRequestID, "")
+	}
+	if r == nil && err == nil {
+		r, err = s.requestService.ProcessNewRequest(ctx, schedule.NewRequest())
+	}
+	span.SetError(err)
+
+	now := s.Clock.Now()
+	if err != nil {
+		schedule.AddError(err, now)
+		if len(schedule.Errors) > maxScheduleErrors {
+			schedule.Errors = schedule.Errors[len(schedule.Errors)-maxScheduleErrors:]
+		}
+	}
+	if r != nil {
+		schedule.LastRequestID = r.ID
+	}
+
+	schedule.LastRun = now
+	schedule.NextRun, err = schedule.NextRunAfter(now)
+	if err != nil {
+		return err
+	}
+
+	return s.scheduleStore.Update(schedule)
+}
+
+// Start checks for due schedules every interval until Stop is called.
+func (s *ScheduleService) Start(interval time.Duration) {
+	s.stop = make(chan bool)
+	s.stopped = make(chan bool)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(s.stopped)
+		for {
+			select {
+			case <-ticker.C:
+				err := s.RunDue()
+				if err != nil {
+					slog.Error("schedule-error", "error", err)
+				}
+			case <-s.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking for due schedules and waits for any run in progress
+// to finish.
+func (s *ScheduleService) Stop() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+		s.stop = nil
+	}
+}
@@ -0,0 +1,14 @@
+package download
+
+import "time"
+
+// ScheduleStore queries are scoped to the tenant given first, except
+// FindDue, which finds the schedules to run across every tenant.
+type ScheduleStore interface {
+	Add(*Schedule) error
+	Update(*Schedule) error
+	Delete(*Schedule) error
+	FindByID(string, string) (*Schedule, error)
+	FindAll(string, uint, uint) ([]*Schedule, error)
+	FindDue(time.Time) ([]*Schedule, error)
+}
@@ -0,0 +1,84 @@
+package download
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// SecretBox seals request secrets with AES-GCM so they are never written
+// to a RequestStore in the clear.
+type SecretBox struct {
+	aead cipher.AEAD
+	// macKey is derived from the key so the key itself is only used by aead
+	macKey []byte
+}
+
+// NewSecretBox ...
+func NewSecretBox(key []byte) (*SecretBox, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("downloaderd-mac"))
+
+	return &SecretBox{aead: aead, macKey: mac.Sum(nil)}, nil
+}
+
+// NewRandomSecretBox ...
+func NewRandomSecretBox() (*SecretBox, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretBox(key)
+}
+
+// Seal ...
+func (b *SecretBox) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open ...
+func (b *SecretBox) Open(sealed string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := b.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("sealed secret too short")
+	}
+
+	return b.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
+// Sum returns an HMAC-SHA256 of data, so digests of secrets can be stored
+// without letting anyone who doesn't have the key guess them.
+func (b *SecretBox) Sum(data []byte) string {
+	mac := hmac.New(sha256.New, b.macKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
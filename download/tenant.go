@@ -0,0 +1,88 @@
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultTenant owns requests made by unauthenticated callers and by
+// principals that don't belong to a tenant.
+const DefaultTenant = ""
+
+// Tenant holds the settings that differ between the teams sharing an
+// instance. Requests and their queries are always scoped to one tenant.
+type Tenant struct {
+	ID             string `json:"id"`
+	CallbackSecret string `json:"callback_secret,omitempty"`
+	PolicyFile     string `json:"policy_file,omitempty"`
+	Quota          *Quota `json:"quota,omitempty"`
+
+	policyFile *PolicyFile
+}
+
+// Policy returns the tenant's policy, or nil if it doesn't have its own.
+func (t *Tenant) Policy() *Policy {
+	if t == nil || t.policyFile == nil {
+		return nil
+	}
+	return t.policyFile.Policy()
+}
+
+// Tenants ...
+type Tenants struct {
+	tenants map[string]*Tenant
+}
+
+// NewTenants loads each tenant's policy file.
+func NewTenants(tenants []*Tenant) (*Tenants, error) {
+	ts := &Tenants{tenants: make(map[string]*Tenant, len(tenants))}
+	for _, t := range tenants {
+		if _, ok := ts.tenants[t.ID]; ok {
+			return nil, fmt.Errorf("tenant '%s' is defined more than once", t.ID)
+		}
+
+		if t.PolicyFile != "" {
+			policyFile, err := NewPolicyFile(t.PolicyFile)
+			if err != nil {
+				return nil, fmt.Errorf("tenant '%s' policy: %v", t.ID, err)
+			}
+			t.policyFile = policyFile
+		}
+		ts.tenants[t.ID] = t
+	}
+	return ts, nil
+}
+
+// LoadTenants reads a JSON array of Tenant from path.
+func LoadTenants(path string) (*Tenants, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tenants []*Tenant
+	err = json.NewDecoder(file).Decode(&tenants)
+	if err != nil {
+		return nil, fmt.Errorf("tenants file %s: %v", path, err)
+	}
+
+	return NewTenants(tenants)
+}
+
+// Quota returns the tenant's quota, or nil if it is unlimited.
+func (ts *Tenants) Quota(id string) *Quota {
+	if t := ts.Get(id); t != nil {
+		return t.Quota
+	}
+	return nil
+}
+
+// Get returns the tenant with the given id, or nil if it isn't configured.
+func (ts *Tenants) Get(id string) *Tenant {
+	if ts == nil {
+		return nil
+	}
+	return ts.tenants[id]
+}
@@ -0,0 +1,108 @@
+package download
+
+import (
+	"context"
+
+	"github.com/patdowney/downloaderd-request/tracing"
+)
+
+// startSpan starts a span about the request.
+func startSpan(ctx context.Context, name string, kind tracing.SpanKind, r *Request) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name, kind)
+	if r.ID != "" {
+		span.SetAttribute("request.id", r.ID)
+	}
+	if r.Tenant != "" {
+		span.SetAttribute("request.tenant", r.Tenant)
+	}
+	return ctx, span
+}
+
+// TracingRequestStore records a span for every operation on a RequestStore.
+type TracingRequestStore struct {
+	RequestStore
+	Backend string
+}
+
+// NewTracingRequestStore ...
+func NewTracingRequestStore(store RequestStore, backend string) *TracingRequestStore {
+	return &TracingRequestStore{RequestStore: store, Backend: backend}
+}
+
+func (s *TracingRequestStore) start(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "RequestStore."+operation, tracing.KindClient)
+	span.SetAttribute("db.system", s.Backend)
+	span.SetAttribute("db.operation", operation)
+	return ctx, span
+}
+
+func endSpan(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+// Add ...
+func (s *TracingRequestStore) Add(ctx context.Context, r *Request) (err error) {
+	ctx, span := s.start(ctx, "Add")
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("request.id", r.ID)
+	return s.RequestStore.Add(ctx, r)
+}
+
+// Update ...
+func (s *TracingRequestStore) Update(ctx context.Context, r *Request) (err error) {
+	ctx, span := s.start(ctx, "Update")
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("request.id", r.ID)
+	return s.RequestStore.Update(ctx, r)
+}
+
+// FindByID ...
+func (s *TracingRequestStore) FindByID(ctx context.Context, tenant string, id string) (r *Request, err error) {
+	ctx, span := s.start(ctx, "FindByID")
+	defer func() { endSpan(span, err) }()
+	span.SetAttribute("request.id", id)
+	return s.RequestStore.FindByID(ctx, tenant, id)
+}
+
+// FindByResourceKey ...
+func (s *TracingRequestStore) FindByResourceKey(ctx context.Context, tenant string, key ResourceKey, offset uint, count uint) (rs []*Request, err error) {
+	ctx, span := s.start(ctx, "FindByResourceKey")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindByResourceKey(ctx, tenant, key, offset, count)
+}
+
+// FindByIdempotencyKey ...
+func (s *TracingRequestStore) FindByIdempotencyKey(ctx context.Context, tenant string, submitter string, key string) (r *Request, err error) {
+	ctx, span := s.start(ctx, "FindByIdempotencyKey")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindByIdempotencyKey(ctx, tenant, submitter, key)
+}
+
+// FindByLabels ...
+func (s *TracingRequestStore) FindByLabels(ctx context.Context, tenant string, owner string, selector LabelSelector, offset uint, count uint) (rs []*Request, err error) {
+	ctx, span := s.start(ctx, "FindByLabels")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindByLabels(ctx, tenant, owner, selector, offset, count)
+}
+
+// FindByOwner ...
+func (s *TracingRequestStore) FindByOwner(ctx context.Context, tenant string, owner string, offset uint, count uint) (rs []*Request, err error) {
+	ctx, span := s.start(ctx, "FindByOwner")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindByOwner(ctx, tenant, owner, offset, count)
+}
+
+// FindAll ...
+func (s *TracingRequestStore) FindAll(ctx context.Context, tenant string, offset uint, count uint) (rs []*Request, err error) {
+	ctx, span := s.start(ctx, "FindAll")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindAll(ctx, tenant, offset, count)
+}
+
+// FindByStates ...
+func (s *TracingRequestStore) FindByStates(ctx context.Context, states []string) (rs []*Request, err error) {
+	ctx, span := s.start(ctx, "FindByStates")
+	defer func() { endSpan(span, err) }()
+	return s.RequestStore.FindByStates(ctx, states)
+}
@@ -1,12 +1,13 @@
 module github.com/patdowney/downloaderd-request
 
-go 1.19
+go 1.21
 
 require (
 	github.com/dancannon/gorethink v4.0.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/patdowney/downloaderd-common v0.0.0-20150409232310-8c9648aa0f86
+	golang.org/x/crypto v0.5.0
 )
 
 require (
@@ -20,7 +21,6 @@ require (
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
-	golang.org/x/crypto v0.5.0 // indirect
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
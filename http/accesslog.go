@@ -0,0 +1,55 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accessRecorder remembers the status code and size of a response.
+type accessRecorder struct {
+	statusRecorder
+	size int
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	n, err := w.statusRecorder.Write(b)
+	w.size += n
+	return n, err
+}
+
+// accessLog serialises writes to the access log.
+type accessLog struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// AccessLog writes a line in the Combined Log Format to w for each call to
+// handler.
+func AccessLog(w io.Writer, handler http.Handler) http.Handler {
+	log := &accessLog{w: w}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &accessRecorder{statusRecorder: statusRecorder{ResponseWriter: rw}}
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+
+		log.Lock()
+		defer log.Unlock()
+		fmt.Fprintf(log.w, "%s - - [%s] %q %d %d %q %q\n",
+			host, start.Format("02/Jan/2006:15:04:05 -0700"),
+			req.Method+" "+req.URL.RequestURI()+" "+req.Proto,
+			recorder.status, recorder.size, req.Referer(), req.UserAgent())
+	})
+}
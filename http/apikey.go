@@ -0,0 +1,90 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// APIKeyHeader carries a static API key.
+const APIKeyHeader = "X-Api-Key"
+
+// APIKey is an entry in an API key file.
+type APIKey struct {
+	Key       string   `json:"key"`
+	Principal string   `json:"principal"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+}
+
+// APIKeyAuthenticator authenticates requests with static API keys.
+type APIKeyAuthenticator struct {
+	keys []apiKeyEntry
+}
+
+type apiKeyEntry struct {
+	digest    [sha256.Size]byte
+	principal download.Principal
+}
+
+// NewAPIKeyAuthenticator ...
+func NewAPIKeyAuthenticator(keys []APIKey) (*APIKeyAuthenticator, error) {
+	a := &APIKeyAuthenticator{}
+	for _, k := range keys {
+		if k.Key == "" || k.Principal == "" {
+			return nil, errors.New("api keys require a key and a principal")
+		} else if strings.ContainsRune(k.Principal+k.Tenant, 0) {
+			return nil, errors.New("api key principals and tenants may not contain NUL")
+		}
+		a.keys = append(a.keys, apiKeyEntry{
+			digest:    sha256.Sum256([]byte(k.Key)),
+			principal: download.Principal{ID: k.Principal, Tenant: k.Tenant, Roles: k.Roles}})
+	}
+	return a, nil
+}
+
+// LoadAPIKeys reads a JSON array of APIKey from path.
+func LoadAPIKeys(path string) (*APIKeyAuthenticator, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	err = json.Unmarshal(contents, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("api key file %s: %v", path, err)
+	}
+
+	return NewAPIKeyAuthenticator(keys)
+}
+
+// Authenticate ...
+func (a *APIKeyAuthenticator) Authenticate(req *http.Request) (*download.Principal, error) {
+	key := req.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+
+	// compare digests in constant time so keys can't be guessed by timing
+	digest := sha256.Sum256([]byte(key))
+	var found *download.Principal
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], a.keys[i].digest[:]) == 1 {
+			p := a.keys[i].principal
+			found = &p
+		}
+	}
+
+	if found == nil {
+		return nil, errors.New("invalid api key")
+	}
+	return found, nil
+}
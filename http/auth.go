@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// ErrUnauthenticated is returned when a request has no credentials any
+// authenticator accepts.
+var ErrUnauthenticated = errors.New("authentication required")
+
+// Authenticator identifies the principal that made a request. It returns
+// nil and no error if the request doesn't carry its kind of credentials,
+// and an error if it does but they are invalid.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*download.Principal, error)
+}
+
+// Authentication is middleware that rejects requests that none of its
+// authenticators accept.
+type Authentication struct {
+	Clock          common.Clock
+	Authenticators []Authenticator
+}
+
+// NewAuthentication ...
+func NewAuthentication(authenticators ...Authenticator) *Authentication {
+	return &Authentication{
+		Clock:          &common.RealClock{},
+		Authenticators: authenticators}
+}
+
+type principalKey struct{}
+
+// PrincipalFromRequest returns the principal added by Authentication, or
+// nil if authentication isn't enabled.
+func PrincipalFromRequest(req *http.Request) *download.Principal {
+	p, _ := req.Context().Value(principalKey{}).(*download.Principal)
+	return p
+}
+
+// Authenticate ...
+func (a *Authentication) Authenticate(req *http.Request) (*download.Principal, error) {
+	for _, authenticator := range a.Authenticators {
+		p, err := authenticator.Authenticate(req)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Middleware ...
+func (a *Authentication) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		p, err := a.Authenticate(req)
+		if err != nil {
+			RequestLogger(req).Warn("authentication-error", "error", err)
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Header().Set("WWW-Authenticate", `Bearer realm="downloaderd"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			encErr := json.NewEncoder(rw).Encode(download.ToAPIError(common.NewTimestampedError(err, a.Clock.Now())))
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+			return
+		}
+
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), principalKey{}, p)))
+	})
+}
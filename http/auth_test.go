@@ -0,0 +1,117 @@
+package http_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dh "github.com/patdowney/downloaderd-request/http"
+)
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks := &dh.JWKS{Keys: []dh.JWK{{
+		KeyType: "EC",
+		KeyID:   "k1",
+		Curve:   "P-256",
+		X:       base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:       base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32)))}}}
+
+	auth, err := dh.NewJWTAuthenticator(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Issuer = "https://issuer.example.com"
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := map[string]interface{}{"sub": "ci", "iss": "https://issuer.example.com", "exp": exp, "roles": []string{"admin"}}
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", signES256(t, key, "k1", valid), true},
+		{"wrong key", signES256(t, other, "k1", valid), false},
+		{"unknown kid", signES256(t, key, "k2", valid), false},
+		{"expired", signES256(t, key, "k1", map[string]interface{}{"sub": "ci", "iss": "https://issuer.example.com", "exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{"wrong issuer", signES256(t, key, "k1", map[string]interface{}{"sub": "ci", "iss": "https://other.example.com", "exp": exp}), false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/request/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+
+		p, err := auth.Authenticate(req)
+		if c.ok {
+			if err != nil || p == nil || p.ID != "ci" || !p.IsAdmin() {
+				t.Errorf("%s: expected admin principal ci, got %v %v", c.name, p, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+
+	scoped := signES256(t, key, "k1", map[string]interface{}{"sub": "ci", "iss": "https://issuer.example.com", "exp": exp, "scope": "admin"})
+	req := httptest.NewRequest("GET", "/request/", nil)
+	req.Header.Set("Authorization", "Bearer "+scoped)
+	p, err := auth.Authenticate(req)
+	if err != nil || p == nil || p.IsAdmin() {
+		t.Errorf("scope: expected non-admin principal, got %v %v", p, err)
+	}
+}
+
+func TestAuthenticationMiddleware(t *testing.T) {
+	apiKeys, err := dh.NewAPIKeyAuthenticator([]dh.APIKey{{Key: "secret", Principal: "team-a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var principal string
+	handler := dh.NewAuthentication(apiKeys).Middleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		principal = dh.PrincipalFromRequest(req).ID
+	}))
+
+	for key, status := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		principal = ""
+		req := httptest.NewRequest("GET", "/request/", nil)
+		if key != "" {
+			req.Header.Set(dh.APIKeyHeader, key)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		if rw.Code != status {
+			t.Errorf("key '%s': expected status %d, got %d", key, status, rw.Code)
+		}
+		if status == http.StatusOK && principal != "team-a" {
+			t.Errorf("key '%s': expected principal team-a, got '%s'", key, principal)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// RequestIDHeader carries the correlation id of a call.
+const RequestIDHeader = download.RequestIDHeader
+
+// maxRequestIDLength bounds correlation ids accepted from clients.
+const maxRequestIDLength = 128
+
+type correlationKey struct{}
+
+// validRequestID accepts printable ASCII ids, so they can't be used to
+// inject log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// NewCorrelationID ...
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// CorrelationIDs is middleware that takes each call's correlation id from
+// X-Request-ID, or generates one, and echoes it in the response.
+func CorrelationIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewCorrelationID()
+		}
+
+		rw.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), correlationKey{}, id)))
+	})
+}
+
+// CorrelationIDFromRequest returns the id added by CorrelationIDs, or ""
+// if the middleware isn't installed.
+func CorrelationIDFromRequest(req *http.Request) string {
+	id, _ := req.Context().Value(correlationKey{}).(string)
+	return id
+}
+
+// RequestLogger returns a logger for events about req.
+func RequestLogger(req *http.Request) *slog.Logger {
+	logger := slog.Default().With("method", req.Method, "path", req.URL.Path)
+	if id := CorrelationIDFromRequest(req); id != "" {
+		logger = logger.With("correlation_id", id)
+	}
+	return logger
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// HealthResource serves liveness and readiness probes. Its routes are
+// absolute, so it should be registered on the root router, and it isn't
+// authenticated or rate limited.
+type HealthResource struct {
+	Readiness *download.Readiness
+}
+
+// NewHealthResource ...
+func NewHealthResource(readiness *download.Readiness) *HealthResource {
+	return &HealthResource{Readiness: readiness}
+}
+
+// RegisterRoutes ...
+func (r *HealthResource) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/healthz", r.Live()).Methods("GET", "HEAD").Name("healthz")
+	router.HandleFunc("/readyz", r.Ready()).Methods("GET", "HEAD").Name("readyz")
+}
+
+func writeHealth(rw http.ResponseWriter, req *http.Request, health *api.Health) {
+	code := http.StatusOK
+	if health.Status != download.HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(code)
+	encErr := json.NewEncoder(rw).Encode(health)
+	if encErr != nil {
+		RequestLogger(req).Error("encode-error", "error", encErr)
+	}
+}
+
+// Live responds while the process is able to serve requests at all.
+func (r *HealthResource) Live() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		writeHealth(rw, req, &api.Health{Status: download.HealthOK})
+	}
+}
+
+// Ready runs the readiness checks and responds 503 if any of them fail.
+func (r *HealthResource) Ready() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		ok, results := r.Readiness.Check(req.Context())
+		for _, result := range results {
+			if result.Err != nil {
+				RequestLogger(req).Warn("readiness-check-failed", "check", result.Name, "error", result.Err)
+			}
+		}
+		writeHealth(rw, req, download.ToAPIHealth(ok, results))
+	}
+}
@@ -0,0 +1,53 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/download"
+	dh "github.com/patdowney/downloaderd-request/http"
+)
+
+func TestReadyReportsFailingChecks(t *testing.T) {
+	readiness := download.NewReadiness(50 * time.Millisecond)
+	readiness.Add("store", func(context.Context) error { return nil })
+	readiness.Add("agent", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	readiness.Add("queue", func(context.Context) error { return errors.New("backlog") })
+
+	router := mux.NewRouter()
+	dh.NewHealthResource(readiness).RegisterRoutes(router)
+
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rw.Code)
+	}
+
+	var health api.Health
+	err := json.NewDecoder(rw.Body).Decode(&health)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{download.HealthOK, download.HealthUnavailable, download.HealthUnavailable}
+	for i, check := range health.Checks {
+		if check.Status != expected[i] {
+			t.Errorf("check %s: expected %s, got %s", check.Name, expected[i], check.Status)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest("GET", "/healthz", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("expected liveness to be unaffected, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,307 @@
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// JWTLeeway is the clock skew allowed when checking exp and nbf.
+const JWTLeeway = time.Minute
+
+// JWK is a JSON web key, only the RSA and EC public key fields are used.
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+}
+
+// JWKS ...
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWTClaims are the claims a JWT must carry. Roles are taken only from the
+// roles claim, as scopes are often granted by clients to themselves, and the
+// tenant from the tenant claim.
+type JWTClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt int64           `json:"exp"`
+	NotBefore int64           `json:"nbf"`
+	Roles     []string        `json:"roles"`
+	Tenant    string          `json:"tenant"`
+}
+
+// HasAudience ...
+func (c *JWTClaims) HasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == audience
+	}
+	var multiple []string
+	if json.Unmarshal(c.Audience, &multiple) == nil {
+		for _, a := range multiple {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTAuthenticator authenticates bearer tokens signed by a key in a local
+// JWKS. Issuer and Audience are checked if set.
+type JWTAuthenticator struct {
+	Clock    common.Clock
+	Issuer   string
+	Audience string
+	keys     map[string]crypto.PublicKey
+}
+
+// NewJWTAuthenticator ...
+func NewJWTAuthenticator(jwks *JWKS) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		Clock: &common.RealClock{},
+		keys:  make(map[string]crypto.PublicKey)}
+
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk '%s': %v", k.KeyID, err)
+		}
+		a.keys[k.KeyID] = key
+	}
+
+	if len(a.keys) == 0 {
+		return nil, errors.New("jwks has no signing keys")
+	}
+	return a, nil
+}
+
+// LoadJWKS reads a JWKS from path.
+func LoadJWKS(path string) (*JWTAuthenticator, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks JWKS
+	err = json.Unmarshal(contents, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("jwks file %s: %v", path, err)
+	}
+
+	return NewJWTAuthenticator(&jwks)
+}
+
+// PublicKey ...
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.KeyType)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Authenticate ...
+func (a *JWTAuthenticator) Authenticate(req *http.Request) (*download.Principal, error) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, nil
+	}
+
+	claims, err := a.Verify(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &download.Principal{ID: claims.Subject, Tenant: claims.Tenant, Roles: claims.Roles}, nil
+}
+
+// Verify checks the token's signature and claims and returns the claims.
+func (a *JWTAuthenticator) Verify(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("jwt header: %v", err)
+	}
+
+	key, ok := a.keys[header.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown jwt key '%s'", header.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("jwt signature: %v", err)
+	}
+
+	err = verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("jwt claims: %v", err)
+	}
+
+	now := a.Clock.Now()
+	if claims.Subject == "" {
+		return nil, errors.New("jwt has no subject")
+	} else if strings.ContainsRune(claims.Subject+claims.Tenant, 0) {
+		return nil, errors.New("jwt subject and tenant may not contain NUL")
+	} else if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(JWTLeeway)) {
+		return nil, errors.New("jwt has expired")
+	} else if claims.NotBefore != 0 && now.Add(JWTLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, errors.New("jwt is not valid yet")
+	} else if a.Issuer != "" && claims.Issuer != a.Issuer {
+		return nil, fmt.Errorf("jwt issuer '%s' is not trusted", claims.Issuer)
+	} else if a.Audience != "" && !claims.HasAudience(a.Audience) {
+		return nil, errors.New("jwt audience does not match")
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	if len(algorithm) != 5 {
+		return fmt.Errorf("unsupported jwt algorithm '%s'", algorithm)
+	}
+
+	var hash crypto.Hash
+	switch algorithm[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported jwt algorithm '%s'", algorithm)
+	}
+
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		d := sha256.Sum256(signed)
+		digest = d[:]
+	case crypto.SHA384:
+		d := sha512.Sum384(signed)
+		digest = d[:]
+	default:
+		d := sha512.Sum512(signed)
+		digest = d[:]
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			break
+		}
+		if rsa.VerifyPKCS1v15(k, hash, digest, signature) != nil {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "ES") {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("jwt algorithm '%s' does not match key", algorithm)
+}
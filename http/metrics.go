@@ -0,0 +1,55 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-request/metrics"
+)
+
+var (
+	httpRequestsTotal = metrics.NewCounterVec("downloaderd_http_requests_total",
+		"HTTP requests by route, method and status code.", "route", "method", "code")
+	httpRequestDuration = metrics.NewHistogramVec("downloaderd_http_request_duration_seconds",
+		"Latency of HTTP requests by route.", nil, "route")
+)
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// InstrumentRoutes is middleware that records metrics for each named route.
+func InstrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		route := "unnamed"
+		if current := mux.CurrentRoute(req); current != nil && current.GetName() != "" {
+			route = current.GetName()
+		}
+
+		recorder := &statusRecorder{ResponseWriter: rw}
+		start := time.Now()
+		next.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		httpRequestDuration.With(route).ObserveSince(start)
+		httpRequestsTotal.With(route, req.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
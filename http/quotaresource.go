@@ -0,0 +1,45 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// QuotaResource reports the caller's quota usage.
+type QuotaResource struct {
+	RequestService *download.RequestService
+	Authentication *Authentication
+	RateLimits     *RateLimits
+}
+
+// NewQuotaResource ...
+func NewQuotaResource(requestService *download.RequestService) *QuotaResource {
+	return &QuotaResource{RequestService: requestService}
+}
+
+// RegisterRoutes ...
+func (r *QuotaResource) RegisterRoutes(parentRouter *mux.Router) {
+	parentRouter.Use(r.RateLimits.AddressMiddleware())
+	if r.Authentication != nil {
+		parentRouter.Use(r.Authentication.Middleware)
+	}
+
+	parentRouter.HandleFunc("/", r.RateLimits.Handler("quota", false, r.Get())).Methods("GET", "HEAD").Name("quota")
+}
+
+// Get ...
+func (r *QuotaResource) Get() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		status := download.ToAPIQuotaStatus(r.RequestService.Quotas, Tenant(req), Submitter(req))
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		encErr := json.NewEncoder(rw).Encode(status)
+		if encErr != nil {
+			RequestLogger(req).Error("encode-error", "error", encErr)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// RateLimit allows Burst requests at once, refilled at Rate per second.
+// A zero Rate is unlimited.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// ParseRateLimit parses 'rate:burst', for example '0.5:10'.
+func ParseRateLimit(s string) (RateLimit, error) {
+	parts := strings.SplitN(s, ":", 2)
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate '%s'", parts[0])
+	}
+
+	burst := int(math.Ceil(rate))
+	if len(parts) == 2 {
+		burst, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return RateLimit{}, fmt.Errorf("invalid burst '%s'", parts[1])
+		}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseRouteRateLimits parses a comma separated list of 'route=rate:burst'.
+func ParseRouteRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid route rate limit '%s'", entry)
+		}
+		limit, err := ParseRateLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket for each key.
+type RateLimiter struct {
+	sync.Mutex
+	Clock   common.Clock
+	Limit   RateLimit
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+// NewRateLimiter ...
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		Clock:   &common.RealClock{},
+		Limit:   limit,
+		buckets: make(map[string]*tokenBucket)}
+}
+
+// sweepInterval is how many takes there are between removing full buckets,
+// which behave the same as missing ones.
+const sweepInterval = 1000
+
+func (l *RateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens = math.Min(float64(l.Limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Limit.Rate)
+	b.last = now
+}
+
+// Take takes a token for key. It returns whether one was available, how
+// many are left, and how long until the bucket is full or, if no token was
+// available, until the next one is.
+func (l *RateLimiter) Take(key string) (bool, int, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.Clock.Now()
+
+	l.takes++
+	if l.takes%sweepInterval == 0 {
+		for k, b := range l.buckets {
+			l.refill(b, now)
+			if b.tokens >= float64(l.Limit.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.Limit.Rate * float64(time.Second))
+		return false, 0, wait
+	}
+
+	b.tokens--
+	full := time.Duration((float64(l.Limit.Burst) - b.tokens) / l.Limit.Rate * float64(time.Second))
+	return true, int(b.tokens), full
+}
+
+// RateLimits limits each client's reads and writes separately. Routes
+// override the limits of individual named routes, which then have buckets
+// of their own. Address limits every request from a remote address before
+// it is authenticated.
+type RateLimits struct {
+	Clock    common.Clock
+	Read     RateLimit
+	Write    RateLimit
+	Address  RateLimit
+	Routes   map[string]RateLimit
+	limiters map[string]*RateLimiter
+}
+
+// NewRateLimits ...
+func NewRateLimits(read RateLimit, write RateLimit) *RateLimits {
+	return &RateLimits{
+		Clock:    &common.RealClock{},
+		Read:     read,
+		Write:    write,
+		Routes:   make(map[string]RateLimit),
+		limiters: make(map[string]*RateLimiter)}
+}
+
+func (rl *RateLimits) limiter(route string, write bool) *RateLimiter {
+	name, limit := "read", rl.Read
+	if write {
+		name, limit = "write", rl.Write
+	}
+	if routeLimit, ok := rl.Routes[route]; ok {
+		name, limit = "route:"+route, routeLimit
+	}
+	return rl.namedLimiter(name, limit)
+}
+
+func (rl *RateLimits) namedLimiter(name string, limit RateLimit) *RateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	l, ok := rl.limiters[name]
+	if !ok {
+		l = NewRateLimiter(limit)
+		l.Clock = rl.Clock
+		rl.limiters[name] = l
+	}
+	return l
+}
+
+// Handler rate limits handler, which serves the named route. It should be
+// called while registering routes, not concurrently with requests.
+func (rl *RateLimits) Handler(route string, write bool, handler http.HandlerFunc) http.HandlerFunc {
+	if rl == nil {
+		return handler
+	}
+
+	l := rl.limiter(route, write)
+	if l == nil {
+		return handler
+	}
+
+	return func(rw http.ResponseWriter, req *http.Request) {
+		ok, remaining, reset := l.Take(Submitter(req))
+
+		rw.Header().Set("RateLimit-Limit", strconv.Itoa(l.Limit.Burst))
+		rw.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		rw.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds(reset)))
+
+		if !ok {
+			RequestLogger(req).Warn("rate-limit-exceeded", "route", route, "submitter", Submitter(req))
+			rl.writeLimited(rw, req, reset)
+			return
+		}
+
+		handler(rw, req)
+	}
+}
+
+// AddressMiddleware returns middleware that rate limits every request by
+// remote address. It goes before authentication, so that guessing
+// credentials is limited too. Like Handler, it should be called while
+// registering routes.
+func (rl *RateLimits) AddressMiddleware() mux.MiddlewareFunc {
+	var l *RateLimiter
+	if rl != nil {
+		l = rl.namedLimiter("address", rl.Address)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			address := RemoteHost(req)
+			ok, _, reset := l.Take(address)
+			if !ok {
+				RequestLogger(req).Warn("address-rate-limit-exceeded", "address", address)
+				rl.writeLimited(rw, req, reset)
+				return
+			}
+
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+func resetSeconds(reset time.Duration) int {
+	return int(math.Ceil(reset.Seconds()))
+}
+
+func (rl *RateLimits) writeLimited(rw http.ResponseWriter, req *http.Request, reset time.Duration) {
+	rw.Header().Set("Retry-After", strconv.Itoa(resetSeconds(reset)))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusTooManyRequests)
+	apiErr := download.ToAPIError(common.NewTimestampedError(errors.New("rate limit exceeded"), rl.Clock.Now()))
+	apiErr.Code = "rate_limited"
+	encErr := json.NewEncoder(rw).Encode(apiErr)
+	if encErr != nil {
+		RequestLogger(req).Error("encode-error", "error", encErr)
+	}
+}
@@ -0,0 +1,86 @@
+package http_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dh "github.com/patdowney/downloaderd-request/http"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestRateLimitsSeparateReadsAndWrites(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limits := dh.NewRateLimits(dh.RateLimit{Rate: 1, Burst: 2}, dh.RateLimit{Rate: 0.5, Burst: 1})
+	limits.Clock = clock
+
+	ok := func(rw http.ResponseWriter, req *http.Request) {}
+	read := limits.Handler("request-index", false, ok)
+	write := limits.Handler("request-create", true, ok)
+
+	serve := func(handler http.HandlerFunc, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/request/", nil)
+		req.RemoteAddr = remoteAddr
+		rw := httptest.NewRecorder()
+		handler(rw, req)
+		return rw
+	}
+
+	if rw := serve(write, "10.0.0.1:1234"); rw.Code != http.StatusOK {
+		t.Fatalf("first write: expected 200, got %d", rw.Code)
+	}
+	rw := serve(write, "10.0.0.1:1234")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("second write: expected 429, got %d", rw.Code)
+	}
+	if rw.Header().Get("Retry-After") != "2" || rw.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected headers %v", rw.Header())
+	}
+
+	// reads and other clients have their own buckets
+	if rw := serve(read, "10.0.0.1:1234"); rw.Code != http.StatusOK || rw.Header().Get("RateLimit-Remaining") != "1" {
+		t.Errorf("read: expected 200 with 1 remaining, got %d %v", rw.Code, rw.Header())
+	}
+	if rw := serve(write, "10.0.0.2:1234"); rw.Code != http.StatusOK {
+		t.Errorf("other client: expected 200, got %d", rw.Code)
+	}
+
+	clock.now = clock.now.Add(2 * time.Second)
+	if rw := serve(write, "10.0.0.1:1234"); rw.Code != http.StatusOK {
+		t.Errorf("after refill: expected 200, got %d", rw.Code)
+	}
+}
+
+func TestAddressLimitAppliesBeforeAuthentication(t *testing.T) {
+	apiKeys, err := dh.NewAPIKeyAuthenticator([]dh.APIKey{{Key: "secret", Principal: "team-a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := dh.NewRateLimits(dh.RateLimit{}, dh.RateLimit{})
+	limits.Clock = &fakeClock{now: time.Now()}
+	limits.Address = dh.RateLimit{Rate: 1, Burst: 2}
+
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler := limits.AddressMiddleware()(dh.NewAuthentication(apiKeys).Middleware(ok))
+
+	for i, status := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/request/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(dh.APIKeyHeader, "wrong")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+
+		if rw.Code != status {
+			t.Errorf("attempt %d: expected status %d, got %d", i, status, rw.Code)
+		}
+	}
+}
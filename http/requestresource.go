@@ -5,7 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 
@@ -15,10 +16,19 @@ import (
 	"github.com/patdowney/downloaderd-request/download"
 )
 
+// Idempotency headers for POST requests.
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	MaxIdempotencyKeyLength  = 255
+)
+
 // RequestResource ...
 type RequestResource struct {
 	Clock          common.Clock
 	RequestService *download.RequestService
+	Authentication *Authentication
+	RateLimits     *RateLimits
 	router         *mux.Router
 	linkResolver   *api.LinkResolver
 }
@@ -33,10 +43,20 @@ func NewRequestResource(requestService *download.RequestService, linkResolver *a
 
 // RegisterRoutes ...
 func (r *RequestResource) RegisterRoutes(parentRouter *mux.Router) {
-	parentRouter.HandleFunc("/", r.Index()).Methods("GET", "HEAD")
-	parentRouter.HandleFunc("/", r.Post()).Methods("POST")
+	parentRouter.Use(InstrumentRoutes, TraceRoutes, r.RateLimits.AddressMiddleware())
+	if r.Authentication != nil {
+		parentRouter.Use(r.Authentication.Middleware)
+	}
+
+	// route names are also used to configure per-route rate limits
+	limit := r.RateLimits.Handler
+	parentRouter.HandleFunc("/", limit("request-index", false, r.Index())).Methods("GET", "HEAD").Name("request-index")
+	parentRouter.HandleFunc("/", limit("request-create", true, r.Post())).Methods("POST").Name("request-create")
 	// regexp matches ids that look like '8671301b-49fa-416c-4bc0-2869963779e5'
-	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}", r.Get()).Methods("GET", "HEAD").Name("request")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}", limit("request", false, r.Get())).Methods("GET", "HEAD").Name("request")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}", limit("request-cancel", true, r.Cancel())).Methods("DELETE").Name("request-cancel")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}/refresh", limit("request-refresh", true, r.Refresh())).Methods("POST").Name("request-refresh")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}/history", limit("request-history", false, r.History())).Methods("GET", "HEAD").Name("request-history")
 
 	r.router = parentRouter
 }
@@ -49,6 +69,70 @@ func (r *RequestResource) populateListLinks(req *http.Request, requestList *[]*a
 
 func (r *RequestResource) populateLinks(req *http.Request, request *api.Request) {
 	request.ResolveLinks(r.linkResolver, req)
+
+	if request.State == download.RequestQueued || request.State == download.RequestScheduled {
+		request.QueuePosition = r.RequestService.QueuePosition(request.ID)
+		request.QueueDepth = r.RequestService.QueueDepth()
+	}
+}
+
+// Submitter identifies the caller that made req, for sharing the dispatch
+// queue fairly.
+func (r *RequestResource) Submitter(req *http.Request) string {
+	return Submitter(req)
+}
+
+// Submitter identifies the caller that made req, by principal if
+// authenticated and otherwise by remote address. Tenant and principal ids
+// are joined with a NUL, which neither may contain, so that they can't
+// collide.
+func Submitter(req *http.Request) string {
+	if p := PrincipalFromRequest(req); p != nil {
+		if p.Tenant != download.DefaultTenant {
+			return p.Tenant + "\x00" + p.ID
+		}
+		return p.ID
+	}
+	return RemoteHost(req)
+}
+
+// RemoteHost returns the host of the address req came from.
+func RemoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Tenant ...
+func (r *RequestResource) Tenant(req *http.Request) string {
+	return Tenant(req)
+}
+
+// Tenant returns the tenant of the caller that made req.
+func Tenant(req *http.Request) string {
+	if p := PrincipalFromRequest(req); p != nil {
+		return p.Tenant
+	}
+	return download.DefaultTenant
+}
+
+// CanAccess returns true if the caller that made req may read or cancel
+// downloadRequest. Everything is accessible if authentication is disabled.
+func (r *RequestResource) CanAccess(req *http.Request, downloadRequest *download.Request) bool {
+	p := PrincipalFromRequest(req)
+	return p == nil || p.CanAccess(downloadRequest)
+}
+
+// findAccessible finds the request with the given id, treating requests the
+// caller may not access as missing so their existence isn't revealed.
+func (r *RequestResource) findAccessible(req *http.Request, requestID string) (*download.Request, error) {
+	downloadRequest, err := r.RequestService.FindByID(req.Context(), r.Tenant(req), requestID)
+	if err != nil || downloadRequest == nil || !r.CanAccess(req, downloadRequest) {
+		return nil, err
+	}
+	return downloadRequest, nil
 }
 
 // WrapError ...
@@ -56,20 +140,43 @@ func (r *RequestResource) WrapError(err error) *api.Error {
 	return download.ToAPIError(common.NewTimestampedError(err, r.Clock.Now()))
 }
 
+func (r *RequestResource) writePolicyError(rw http.ResponseWriter, policyErr *download.PolicyError) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	encoder := json.NewEncoder(rw)
+	encErr := encoder.Encode(download.ToAPIPolicyError(policyErr, r.Clock.Now()))
+	if encErr != nil {
+		slog.Error("encode-error", "error", encErr)
+	}
+}
+
 // Index ...
 func (r *RequestResource) Index() http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		requestList, err := r.RequestService.ListAll()
+		var requestList []*download.Request
+		var err error
+
+		if selector := req.URL.Query().Get("labels"); selector != "" {
+			labelSelector, parseErr := download.ParseLabelSelector(selector)
+			if parseErr != nil {
+				RequestLogger(req).Warn("label-selector-error", "error", parseErr)
+				r.writeError(rw, http.StatusBadRequest, parseErr)
+				return
+			}
+			requestList, err = r.RequestService.FindByLabelsForPrincipal(req.Context(), PrincipalFromRequest(req), labelSelector)
+		} else {
+			requestList, err = r.RequestService.ListForPrincipal(req.Context(), PrincipalFromRequest(req))
+		}
 
 		encoder := json.NewEncoder(rw)
 		rw.Header().Set("Content-Type", "application/json")
 
 		if err != nil {
-			log.Printf("server-error: %v", err)
+			RequestLogger(req).Error("server-error", "error", err)
 			rw.WriteHeader(http.StatusInternalServerError)
 			encErr := encoder.Encode(r.WrapError(err))
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 		} else {
 			rw.WriteHeader(http.StatusOK)
@@ -77,7 +184,7 @@ func (r *RequestResource) Index() http.HandlerFunc {
 			r.populateListLinks(req, rl)
 			encErr := encoder.Encode(rl)
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 		}
 	}
@@ -89,17 +196,17 @@ func (r *RequestResource) Get() http.HandlerFunc {
 		vars := mux.Vars(req)
 		requestID := vars["id"]
 
-		downloadRequest, err := r.RequestService.FindByID(requestID)
+		downloadRequest, err := r.findAccessible(req, requestID)
 
 		encoder := json.NewEncoder(rw)
 		rw.Header().Set("Content-Type", "application/json")
 
 		if err != nil {
-			log.Printf("server-error: %v", err)
+			RequestLogger(req).Error("server-error", "error", err)
 			rw.WriteHeader(http.StatusInternalServerError)
 			encErr := encoder.Encode(r.WrapError(err))
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 		} else if downloadRequest != nil {
 			rw.WriteHeader(http.StatusOK)
@@ -107,23 +214,174 @@ func (r *RequestResource) Get() http.HandlerFunc {
 			r.populateLinks(req, dr)
 			encErr := encoder.Encode(dr)
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 		} else {
 			errMessage := fmt.Sprintf("Unable to find request with id:%s", requestID)
-			log.Printf("server-error: %v", errMessage)
+			RequestLogger(req).Error("server-error", "error", errMessage)
 
 			rw.WriteHeader(http.StatusNotFound)
 			encErr := encoder.Encode(errors.New(errMessage))
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+		}
+	}
+}
+
+// Refresh ...
+func (r *RequestResource) Refresh() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		requestID := vars["id"]
+
+		var downloadRequest *download.Request
+		created := false
+		existing, err := r.findAccessible(req, requestID)
+		if err == nil && existing != nil {
+			downloadRequest, created, err = r.RequestService.Refresh(req.Context(), r.Tenant(req), requestID, CorrelationIDFromRequest(req))
+		}
+
+		encoder := json.NewEncoder(rw)
+		rw.Header().Set("Content-Type", "application/json")
+
+		if policyErr, ok := err.(*download.PolicyError); ok {
+			RequestLogger(req).Warn("request-policy-error", "error", err)
+			r.writePolicyError(rw, policyErr)
+		} else if quotaErr, ok := err.(*download.QuotaError); ok {
+			RequestLogger(req).Warn("request-quota-error", "error", err)
+			r.writeQuotaError(rw, quotaErr)
+		} else if err != nil {
+			RequestLogger(req).Error("server-error", "error", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			encErr := encoder.Encode(r.WrapError(err))
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+		} else if downloadRequest != nil {
+			if created {
+				newURL, _ := r.GetRequestURL(downloadRequest.ID)
+				rw.Header().Set("Location", newURL.String())
+				rw.WriteHeader(http.StatusAccepted)
+			} else {
+				rw.WriteHeader(http.StatusOK)
+			}
+			dr := download.ToAPIRequest(downloadRequest)
+			r.populateLinks(req, dr)
+			encErr := encoder.Encode(dr)
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+		} else {
+			r.writeNotFound(rw, requestID)
+		}
+	}
+}
+
+// Cancel ...
+func (r *RequestResource) Cancel() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		requestID := vars["id"]
+
+		var downloadRequest *download.Request
+		existing, err := r.findAccessible(req, requestID)
+		if err == nil && existing != nil {
+			downloadRequest, err = r.RequestService.Cancel(req.Context(), r.Tenant(req), requestID)
+		}
+
+		if err == download.ErrNotCancellable {
+			RequestLogger(req).Warn("request-cancel-error", "error", err)
+			r.writeError(rw, http.StatusConflict, err)
+		} else if err != nil {
+			RequestLogger(req).Error("server-error", "error", err)
+			r.writeError(rw, http.StatusInternalServerError, err)
+		} else if downloadRequest != nil {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			dr := download.ToAPIRequest(downloadRequest)
+			r.populateLinks(req, dr)
+			encErr := json.NewEncoder(rw).Encode(dr)
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+		} else {
+			rw.Header().Set("Content-Type", "application/json")
+			r.writeNotFound(rw, requestID)
+		}
+	}
+}
+
+// History ...
+func (r *RequestResource) History() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		requestID := vars["id"]
+
+		var history []*download.Request
+		existing, err := r.findAccessible(req, requestID)
+		if err == nil && existing != nil {
+			history, err = r.RequestService.History(req.Context(), r.Tenant(req), requestID)
+		}
+
+		encoder := json.NewEncoder(rw)
+		rw.Header().Set("Content-Type", "application/json")
+
+		if err != nil {
+			RequestLogger(req).Error("server-error", "error", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			encErr := encoder.Encode(r.WrapError(err))
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
+			}
+		} else if history != nil {
+			rw.WriteHeader(http.StatusOK)
+			rl := download.ToAPIRequestList(&history)
+			r.populateListLinks(req, rl)
+			encErr := encoder.Encode(rl)
+			if encErr != nil {
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
+		} else {
+			r.writeNotFound(rw, requestID)
 		}
 	}
 }
 
+func (r *RequestResource) writeError(rw http.ResponseWriter, statusCode int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+	encErr := json.NewEncoder(rw).Encode(r.WrapError(err))
+	if encErr != nil {
+		slog.Error("encode-error", "error", encErr)
+	}
+}
+
+func (r *RequestResource) writeQuotaError(rw http.ResponseWriter, quotaErr *download.QuotaError) {
+	if !quotaErr.ResetAt.IsZero() {
+		rw.Header().Set("Retry-After", quotaErr.ResetAt.UTC().Format(http.TimeFormat))
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusTooManyRequests)
+	encErr := json.NewEncoder(rw).Encode(download.ToAPIQuotaError(quotaErr, r.Clock.Now()))
+	if encErr != nil {
+		slog.Error("encode-error", "error", encErr)
+	}
+}
+
+func (r *RequestResource) writeNotFound(rw http.ResponseWriter, requestID string) {
+	errMessage := fmt.Sprintf("Unable to find request with id:%s", requestID)
+	slog.Error("server-error", "error", errMessage)
+
+	rw.WriteHeader(http.StatusNotFound)
+	encErr := json.NewEncoder(rw).Encode(r.WrapError(errors.New(errMessage)))
+	if encErr != nil {
+		slog.Error("encode-error", "error", encErr)
+	}
+}
+
 // ValidateIncomingRequest ...
-func (r *RequestResource) ValidateIncomingRequest(inReq *api.IncomingRequest) error {
+func (r *RequestResource) ValidateIncomingRequest(tenant string, inReq *api.IncomingRequest) error {
 	if inReq.URL == "" {
 		return errors.New("empty url")
 	}
@@ -134,6 +392,94 @@ func (r *RequestResource) ValidateIncomingRequest(inReq *api.IncomingRequest) er
 	} else if u.Scheme != "http" && u.Scheme != "https" {
 		return fmt.Errorf("unsupported url scheme: '%s'", u.Scheme)
 	}
+
+	if r.RequestService != nil {
+		err = r.RequestService.CheckURL(tenant, u)
+		if _, ok := err.(*download.PolicyError); ok {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("url not allowed: %v", err)
+		}
+	}
+
+	if inReq.Priority < download.MinPriority || inReq.Priority > download.MaxPriority {
+		return fmt.Errorf("priority must be between %d and %d", download.MinPriority, download.MaxPriority)
+	}
+
+	if !inReq.Deadline.IsZero() {
+		if inReq.Deadline.Before(r.Clock.Now()) {
+			return errors.New("deadline is in the past")
+		} else if inReq.Deadline.Before(inReq.NotBefore) {
+			return errors.New("deadline is before not_before")
+		}
+	}
+
+	if inReq.Checksum != "" {
+		_, _, err = download.NormalizeChecksum(inReq.Checksum, inReq.ChecksumType)
+		if err != nil {
+			return err
+		}
+	} else if inReq.ChecksumType != "" {
+		_, err = download.LookupChecksumAlgorithm(inReq.ChecksumType)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range inReq.Checksums {
+		_, _, err = download.NormalizeChecksum(c.Value, c.Type)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inReq.Integrity != "" {
+		_, err = download.ParseIntegrity(inReq.Integrity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inReq.ChecksumURL != "" {
+		if inReq.Checksum != "" {
+			return errors.New("checksum and checksum_url are mutually exclusive")
+		}
+
+		cu, err := url.Parse(inReq.ChecksumURL)
+		if err != nil {
+			return err
+		} else if cu.Scheme != "http" && cu.Scheme != "https" {
+			return fmt.Errorf("unsupported checksum url scheme: '%s'", cu.Scheme)
+		}
+
+		if r.RequestService != nil {
+			err = r.RequestService.CheckURL(tenant, cu)
+			if _, ok := err.(*download.PolicyError); ok {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("checksum url not allowed: %v", err)
+			}
+		}
+	}
+
+	for name := range inReq.Headers {
+		if name == "" {
+			return errors.New("empty header name")
+		}
+	}
+
+	err = download.ValidateLabels(inReq.Labels)
+	if err != nil {
+		return err
+	}
+
+	if inReq.Credentials != nil {
+		if inReq.Credentials.Token != "" && inReq.Credentials.Username != "" {
+			return errors.New("credentials must be either basic or bearer, not both")
+		} else if inReq.Credentials.Token == "" && inReq.Credentials.Username == "" {
+			return errors.New("credentials require a username or token")
+		}
+	}
 	return nil
 }
 
@@ -163,41 +509,80 @@ func (r *RequestResource) Post() http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		apiIncomingRequest, err := r.DecodeInputRequest(req.Body)
 		if err != nil {
-			log.Printf("incoming-request-decode-error: %v", err)
+			RequestLogger(req).Warn("incoming-request-decode-error", "error", err)
 			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err = r.ValidateIncomingRequest(apiIncomingRequest)
-		if err != nil {
-			log.Printf("incoming-request-validation-error: %v", err)
+		err = r.ValidateIncomingRequest(r.Tenant(req), apiIncomingRequest)
+		if policyErr, ok := err.(*download.PolicyError); ok {
+			RequestLogger(req).Warn("incoming-request-policy-error", "error", err)
+			r.writePolicyError(rw, policyErr)
+			return
+		} else if err != nil {
+			RequestLogger(req).Warn("incoming-request-validation-error", "error", err)
 			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		inReq := download.FromAPIIncomingRequest(apiIncomingRequest)
-		downloadRequest, err := r.RequestService.ProcessNewRequest(inReq)
+		idempotencyKey := req.Header.Get(IdempotencyKeyHeader)
+		if len(idempotencyKey) > MaxIdempotencyKeyLength {
+			RequestLogger(req).Warn("incoming-request-validation-error", "error", "idempotency key too long")
+			http.Error(rw, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, MaxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
 
+		inReq, err := download.FromAPIIncomingRequest(apiIncomingRequest)
 		if err != nil {
-			log.Printf("request-processing-error: %v", err)
+			RequestLogger(req).Warn("incoming-request-validation-error", "error", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		inReq.Submitter = r.Submitter(req)
+		inReq.CorrelationID = CorrelationIDFromRequest(req)
+		inReq.Tenant = r.Tenant(req)
+		if p := PrincipalFromRequest(req); p != nil {
+			inReq.Owner = p.ID
+		}
+		inReq.IdempotencyKey = idempotencyKey
+		inReq.BodyHash = download.HashIncomingRequest(r.RequestService.SecretBox, apiIncomingRequest)
+		downloadRequest, replayed, err := r.RequestService.ProcessIdempotentRequest(req.Context(), inReq)
+
+		if policyErr, ok := err.(*download.PolicyError); ok {
+			RequestLogger(req).Warn("request-policy-error", "error", err)
+			r.writePolicyError(rw, policyErr)
+		} else if quotaErr, ok := err.(*download.QuotaError); ok {
+			RequestLogger(req).Warn("request-quota-error", "error", err)
+			r.writeQuotaError(rw, quotaErr)
+		} else if err == download.ErrIdempotencyKeyMismatch {
+			RequestLogger(req).Warn("request-idempotency-error", "error", err)
+			r.writeError(rw, http.StatusUnprocessableEntity, err)
+		} else if err == download.ErrIdempotencyKeyInUse {
+			RequestLogger(req).Warn("request-idempotency-error", "error", err)
+			r.writeError(rw, http.StatusConflict, err)
+		} else if err != nil {
+			RequestLogger(req).Error("request-processing-error", "error", err)
 			rw.Header().Set("Content-Type", "application/json")
 			rw.WriteHeader(http.StatusInternalServerError)
 			encoder := json.NewEncoder(rw)
 			encErr := encoder.Encode(r.WrapError(err))
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 		} else {
 			newURL, _ := r.GetRequestURL(downloadRequest.ID)
 			rw.Header().Set("Content-Type", "application/json")
 			rw.Header().Set("Location", newURL.String())
+			if replayed {
+				rw.Header().Set(IdempotentReplayedHeader, "true")
+			}
 			rw.WriteHeader(http.StatusAccepted)
 			encoder := json.NewEncoder(rw)
 			dr := download.ToAPIRequest(downloadRequest)
 			r.populateLinks(req, dr)
 			encErr := encoder.Encode(dr)
 			if encErr != nil {
-				log.Printf("encode-error: %v", encErr)
+				RequestLogger(req).Error("encode-error", "error", encErr)
 			}
 
 		}
@@ -1,14 +1,20 @@
 package http_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
 
 	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/download"
 	dh "github.com/patdowney/downloaderd-request/http"
+	"github.com/patdowney/downloaderd-request/local"
 )
 
 func TestURLResolving(t *testing.T) {
@@ -65,8 +71,8 @@ func TestParseCompleteRequest(t *testing.T) {
 		Callback:     "http://example.com/callback"}
 
 	r, _ := res.DecodeInputRequest(incomingJSON)
-	if *r != expectedIncoming {
-		t.Errorf(`DecodeInputRequest('%s') = %q want %q`, jsonString, r, expectedIncoming)
+	if !reflect.DeepEqual(*r, expectedIncoming) {
+		t.Errorf(`DecodeInputRequest('%s') = %v want %v`, jsonString, r, expectedIncoming)
 	}
 }
 
@@ -79,8 +85,8 @@ func TestParseWithPartialRequest(t *testing.T) {
 	expectedIncoming := api.IncomingRequest{URL: "http://example.com/some/resource"}
 
 	r, _ := res.DecodeInputRequest(incomingJSON)
-	if *r != expectedIncoming {
-		t.Errorf(`DecodeInputRequest('%s') = %q want %q`, jsonString, r, expectedIncoming)
+	if !reflect.DeepEqual(*r, expectedIncoming) {
+		t.Errorf(`DecodeInputRequest('%s') = %v want %v`, jsonString, r, expectedIncoming)
 	}
 }
 
@@ -158,3 +164,78 @@ func TestRequestResourcePostIncomingRequest(t *testing.T) {}
 //		}
 //	}
 //}
+
+func newRequestRouter(t *testing.T) *mux.Router {
+	store, _ := local.NewRequestStore(filepath.Join(t.TempDir(), "requests.json"))
+	box, err := download.NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestService := download.NewRequestService(store, nil, box)
+	requestService.MetadataClient = download.NewMetadataClient(download.NewRedirectPolicy(), nil)
+
+	router := mux.NewRouter()
+	res := dh.NewRequestResource(requestService, api.NewLinkResolver(router))
+	res.RegisterRoutes(router)
+	return router
+}
+
+func postRequest(router *mux.Router, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set(dh.IdempotencyKeyHeader, key)
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestIdempotentPostReplaysOriginalResponse(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer origin.Close()
+	router := newRequestRouter(t)
+
+	body := `{"url":"` + origin.URL + `/file","credentials":{"token":"secret"}}`
+	first := postRequest(router, "key", body)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, first.Code, first.Body)
+	}
+
+	replay := postRequest(router, "key", body)
+	if replay.Code != http.StatusAccepted || replay.Header().Get(dh.IdempotentReplayedHeader) != "true" {
+		t.Errorf("expected a replayed %d, got %d %v", http.StatusAccepted, replay.Code, replay.Header())
+	}
+	if replay.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("expected the original request at %s, got %s", first.Header().Get("Location"), replay.Header().Get("Location"))
+	}
+
+	different := postRequest(router, "key", `{"url":"`+origin.URL+`/file","credentials":{"token":"other"}}`)
+	if different.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d for a different body, got %d", http.StatusUnprocessableEntity, different.Code)
+	}
+}
+
+func TestIdempotentPostInProgressConflicts(t *testing.T) {
+	probing := make(chan bool, 1)
+	release := make(chan bool)
+	origin := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		probing <- true
+		<-release
+	}))
+	defer origin.Close()
+	router := newRequestRouter(t)
+
+	body := `{"url":"` + origin.URL + `/file"}`
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postRequest(router, "key", body)
+	}()
+	<-probing
+
+	concurrent := postRequest(router, "key", body)
+	close(release)
+	if concurrent.Code != http.StatusConflict {
+		t.Errorf("expected %d while the first request is in progress, got %d", http.StatusConflict, concurrent.Code)
+	}
+	if first := <-done; first.Code != http.StatusAccepted {
+		t.Errorf("expected %d, got %d: %s", http.StatusAccepted, first.Code, first.Body)
+	}
+}
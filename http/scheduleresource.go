@@ -0,0 +1,311 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-common/common"
+	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// MinScheduleInterval ...
+const MinScheduleInterval = time.Minute
+
+// ScheduleResource ...
+type ScheduleResource struct {
+	Clock           common.Clock
+	ScheduleService *download.ScheduleService
+	Authentication  *Authentication
+	RateLimits      *RateLimits
+	router          *mux.Router
+	linkResolver    *api.LinkResolver
+}
+
+// NewScheduleResource ...
+func NewScheduleResource(scheduleService *download.ScheduleService, linkResolver *api.LinkResolver) *ScheduleResource {
+	return &ScheduleResource{
+		Clock:           &common.RealClock{},
+		ScheduleService: scheduleService,
+		linkResolver:    linkResolver}
+}
+
+// RegisterRoutes ...
+func (r *ScheduleResource) RegisterRoutes(parentRouter *mux.Router) {
+	parentRouter.Use(InstrumentRoutes, TraceRoutes, r.RateLimits.AddressMiddleware())
+	if r.Authentication != nil {
+		parentRouter.Use(r.Authentication.Middleware)
+	}
+
+	// route names are also used to configure per-route rate limits
+	limit := r.RateLimits.Handler
+	parentRouter.HandleFunc("/", limit("schedule-index", false, r.Index())).Methods("GET", "HEAD").Name("schedule-index")
+	parentRouter.HandleFunc("/", limit("schedule-create", true, r.Post())).Methods("POST").Name("schedule-create")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}", limit("schedule", false, r.Get())).Methods("GET", "HEAD").Name("schedule")
+	parentRouter.HandleFunc("/{id:[a-f0-9-]{36}}", limit("schedule-delete", true, r.Delete())).Methods("DELETE").Name("schedule-delete")
+
+	r.router = parentRouter
+}
+
+func (r *ScheduleResource) populateListLinks(req *http.Request, scheduleList *[]*api.Schedule) {
+	for _, s := range *scheduleList {
+		r.populateLinks(req, s)
+	}
+}
+
+func (r *ScheduleResource) populateLinks(req *http.Request, schedule *api.Schedule) {
+	schedule.ResolveLinks(r.linkResolver, req)
+}
+
+// CanAccess returns true if the caller that made req may read or delete
+// schedule. Everything is accessible if authentication is disabled.
+func (r *ScheduleResource) CanAccess(req *http.Request, schedule *download.Schedule) bool {
+	p := PrincipalFromRequest(req)
+	return p == nil || p.CanAccessSchedule(schedule)
+}
+
+func (r *ScheduleResource) accessible(req *http.Request, scheduleList []*download.Schedule) []*download.Schedule {
+	results := make([]*download.Schedule, 0, len(scheduleList))
+	for _, schedule := range scheduleList {
+		if r.CanAccess(req, schedule) {
+			results = append(results, schedule)
+		}
+	}
+	return results
+}
+
+// WrapError ...
+func (r *ScheduleResource) WrapError(err error) *api.Error {
+	return download.ToAPIError(common.NewTimestampedError(err, r.Clock.Now()))
+}
+
+func (r *ScheduleResource) writeError(rw http.ResponseWriter, status int, err error) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	encErr := json.NewEncoder(rw).Encode(r.WrapError(err))
+	if encErr != nil {
+		slog.Error("encode-error", "error", encErr)
+	}
+}
+
+func (r *ScheduleResource) writeSchedule(rw http.ResponseWriter, req *http.Request, status int, schedule *download.Schedule) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	s := download.ToAPISchedule(schedule)
+	r.populateLinks(req, s)
+	encErr := json.NewEncoder(rw).Encode(s)
+	if encErr != nil {
+		RequestLogger(req).Error("encode-error", "error", encErr)
+	}
+}
+
+// Index ...
+func (r *ScheduleResource) Index() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		scheduleList, err := r.ScheduleService.ListAll(Tenant(req))
+		if err != nil {
+			RequestLogger(req).Error("server-error", "error", err)
+			r.writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		scheduleList = r.accessible(req, scheduleList)
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		sl := download.ToAPIScheduleList(&scheduleList)
+		r.populateListLinks(req, sl)
+		encErr := json.NewEncoder(rw).Encode(sl)
+		if encErr != nil {
+			RequestLogger(req).Error("encode-error", "error", encErr)
+		}
+	}
+}
+
+func (r *ScheduleResource) findSchedule(rw http.ResponseWriter, req *http.Request) *download.Schedule {
+	scheduleID := mux.Vars(req)["id"]
+
+	// schedules the caller may not access are treated as missing so their
+	// existence isn't revealed
+	schedule, err := r.ScheduleService.FindByID(Tenant(req), scheduleID)
+	if err == nil && schedule != nil && !r.CanAccess(req, schedule) {
+		schedule = nil
+	}
+
+	if err != nil {
+		RequestLogger(req).Error("server-error", "error", err)
+		r.writeError(rw, http.StatusInternalServerError, err)
+		return nil
+	} else if schedule == nil {
+		errMessage := fmt.Sprintf("Unable to find schedule with id:%s", scheduleID)
+		RequestLogger(req).Error("server-error", "error", errMessage)
+		r.writeError(rw, http.StatusNotFound, errors.New(errMessage))
+	}
+	return schedule
+}
+
+// Get ...
+func (r *ScheduleResource) Get() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		schedule := r.findSchedule(rw, req)
+		if schedule != nil {
+			r.writeSchedule(rw, req, http.StatusOK, schedule)
+		}
+	}
+}
+
+// Delete ...
+func (r *ScheduleResource) Delete() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		schedule := r.findSchedule(rw, req)
+		if schedule == nil {
+			return
+		}
+
+		err := r.ScheduleService.Delete(schedule)
+		if err != nil {
+			RequestLogger(req).Error("server-error", "error", err)
+			r.writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ValidateIncomingSchedule checks inSched, and its URL against the tenant's
+// policy.
+func (r *ScheduleResource) ValidateIncomingSchedule(tenant string, inSched *api.IncomingSchedule) error {
+	if inSched.URL == "" {
+		return errors.New("empty url")
+	}
+
+	u, err := url.Parse(inSched.URL)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme: '%s'", u.Scheme)
+	}
+
+	if r.ScheduleService != nil {
+		err = r.ScheduleService.CheckURL(tenant, u)
+		if _, ok := err.(*download.PolicyError); ok {
+			return err
+		} else if err != nil {
+			return fmt.Errorf("url not allowed: %v", err)
+		}
+	}
+
+	if (inSched.Cron == "") == (inSched.Interval == "") {
+		return errors.New("exactly one of cron or interval is required")
+	}
+
+	if inSched.Cron != "" {
+		_, err = download.ParseCron(inSched.Cron)
+		if err != nil {
+			return err
+		}
+	} else {
+		interval, err := time.ParseDuration(inSched.Interval)
+		if err != nil {
+			return err
+		} else if interval < MinScheduleInterval {
+			return fmt.Errorf("interval must be at least %v", MinScheduleInterval)
+		}
+	}
+
+	if inSched.ChecksumType != "" {
+		_, err = download.LookupChecksumAlgorithm(inSched.ChecksumType)
+		if err != nil {
+			return err
+		}
+	}
+
+	if inSched.ChecksumURL != "" {
+		cu, err := url.Parse(inSched.ChecksumURL)
+		if err != nil {
+			return err
+		} else if cu.Scheme != "http" && cu.Scheme != "https" {
+			return fmt.Errorf("unsupported checksum url scheme: '%s'", cu.Scheme)
+		}
+
+		if r.ScheduleService != nil {
+			err = r.ScheduleService.CheckURL(tenant, cu)
+			if _, ok := err.(*download.PolicyError); ok {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("checksum url not allowed: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DecodeIncomingSchedule ...
+func (r *ScheduleResource) DecodeIncomingSchedule(body io.Reader) (*api.IncomingSchedule, error) {
+	var inSched api.IncomingSchedule
+	err := json.NewDecoder(body).Decode(&inSched)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inSched, nil
+}
+
+// GetScheduleURL ...
+func (r *ScheduleResource) GetScheduleURL(id string) (*url.URL, error) {
+	if r.router != nil {
+		return r.router.Get("schedule").URL("id", id)
+	}
+
+	return nil, errors.New("no router set")
+}
+
+// Post ...
+func (r *ScheduleResource) Post() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		apiIncomingSchedule, err := r.DecodeIncomingSchedule(req.Body)
+		if err != nil {
+			RequestLogger(req).Warn("incoming-schedule-decode-error", "error", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = r.ValidateIncomingSchedule(Tenant(req), apiIncomingSchedule)
+		if err != nil {
+			RequestLogger(req).Warn("incoming-schedule-validation-error", "error", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inSched, err := download.FromAPIIncomingSchedule(apiIncomingSchedule)
+		if err != nil {
+			RequestLogger(req).Warn("incoming-schedule-validation-error", "error", err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		inSched.Tenant = Tenant(req)
+		inSched.Submitter = Submitter(req)
+		if p := PrincipalFromRequest(req); p != nil {
+			inSched.Owner = p.ID
+		}
+
+		schedule, err := r.ScheduleService.AddSchedule(inSched)
+		if err != nil {
+			RequestLogger(req).Error("schedule-processing-error", "error", err)
+			r.writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+
+		newURL, _ := r.GetScheduleURL(schedule.ID)
+		rw.Header().Set("Location", newURL.String())
+		r.writeSchedule(rw, req, http.StatusCreated, schedule)
+	}
+}
@@ -0,0 +1,27 @@
+package http_test
+
+import (
+	"testing"
+
+	"github.com/patdowney/downloaderd-request/api"
+	"github.com/patdowney/downloaderd-request/download"
+	dh "github.com/patdowney/downloaderd-request/http"
+)
+
+func TestScheduleChecksumURLIsGuarded(t *testing.T) {
+	box, err := download.NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestService := download.NewRequestService(nil, nil, box)
+	requestService.MetadataClient = download.NewMetadataClient(download.NewRedirectPolicy(), download.NewAddressGuard())
+	r := dh.NewScheduleResource(download.NewScheduleService(nil, requestService), nil)
+
+	err = r.ValidateIncomingSchedule(download.DefaultTenant, &api.IncomingSchedule{
+		URL:         "http://93.184.216.34/file",
+		Interval:    "1h",
+		ChecksumURL: "http://127.0.0.1/SHA256SUMS"})
+	if err == nil {
+		t.Errorf("expected a checksum url on a blocked address to be rejected")
+	}
+}
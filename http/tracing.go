@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/patdowney/downloaderd-request/tracing"
+)
+
+// TraceRoutes is middleware that records a server span for each call,
+// continuing the trace of an incoming traceparent header.
+func TraceRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		route := "unnamed"
+		template := req.URL.Path
+		if current := mux.CurrentRoute(req); current != nil {
+			if current.GetName() != "" {
+				route = current.GetName()
+			}
+			if t, err := current.GetPathTemplate(); err == nil {
+				template = t
+			}
+		}
+
+		ctx := tracing.Extract(req.Context(), req.Header)
+		ctx, span := tracing.Start(ctx, req.Method+" "+template, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.route", template)
+		span.SetAttribute("route.name", route)
+		if id := CorrelationIDFromRequest(req); id != "" {
+			span.SetAttribute("correlation_id", id)
+		}
+
+		recorder := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(recorder, req.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetError(&statusError{recorder.status})
+		}
+	})
+}
+
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return http.StatusText(e.status)
+}
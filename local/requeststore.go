@@ -1,17 +1,30 @@
 package local
 
 import (
+	"context"
+	"fmt"
+	"reflect"
 	"sync"
 
 	"github.com/patdowney/downloaderd-common/local"
 	"github.com/patdowney/downloaderd-request/download"
 )
 
-// RequestStore ...
+// RequestStore keeps requests in memory and writes them all to a JSON file
+// on every change. Like a database it stores and returns copies, so callers
+// can't change stored requests without calling Update.
 type RequestStore struct {
 	local.JSONStore
 	sync.RWMutex
 	repository []*download.Request
+	// tenantIndex maps tenants to positions in repository
+	tenantIndex map[string][]int
+	// labelIndex maps tenants' label pairs to positions in repository
+	labelIndex map[string][]int
+	// ownerLabelIndex maps owners' label pairs to positions in repository
+	ownerLabelIndex map[string][]int
+	// saveErr is the error from the last write to disk, if it failed
+	saveErr error
 }
 
 // NewRequestStore ...
@@ -22,53 +35,205 @@ func NewRequestStore(dataFile string) (*RequestStore, error) {
 	requestStore.DataFile = dataFile
 
 	err := requestStore.LoadFromDisk(&requestStore.repository)
+	requestStore.reindex()
 
 	return requestStore, err
 }
 
+func labelKey(tenant string, pair string) string {
+	return tenant + "\x00" + pair
+}
+
+func ownerLabelKey(tenant string, owner string, pair string) string {
+	return tenant + "\x00" + owner + "\x00" + pair
+}
+
+func (s *RequestStore) reindex() {
+	s.tenantIndex = make(map[string][]int)
+	s.labelIndex = make(map[string][]int)
+	s.ownerLabelIndex = make(map[string][]int)
+	for i := range s.repository {
+		s.index(i)
+	}
+}
+
+func (s *RequestStore) index(i int) {
+	request := s.repository[i]
+	s.tenantIndex[request.Tenant] = append(s.tenantIndex[request.Tenant], i)
+	for _, pair := range download.LabelPairs(request.Labels) {
+		key := labelKey(request.Tenant, pair)
+		s.labelIndex[key] = append(s.labelIndex[key], i)
+		key = ownerLabelKey(request.Tenant, request.Owner, pair)
+		s.ownerLabelIndex[key] = append(s.ownerLabelIndex[key], i)
+	}
+}
+
+// find returns the tenant's requests that match, using the label index if
+// labelPair is set.
+func (s *RequestStore) find(tenant string, labelPair string, match func(*download.Request) bool) []*download.Request {
+	positions := s.tenantIndex[tenant]
+	if labelPair != "" {
+		positions = s.labelIndex[labelKey(tenant, labelPair)]
+	}
+	return s.findAt(positions, match)
+}
+
+// findAt returns the requests at positions that match.
+func (s *RequestStore) findAt(positions []int, match func(*download.Request) bool) []*download.Request {
+	results := make([]*download.Request, 0, len(positions))
+	for _, i := range positions {
+		if match(s.repository[i]) {
+			results = append(results, s.repository[i].Copy())
+		}
+	}
+	return results
+}
+
 // Add ...
-func (s *RequestStore) Add(request *download.Request) error {
+func (s *RequestStore) Add(ctx context.Context, request *download.Request) error {
 	s.Lock()
 	defer s.Unlock()
-	s.repository = append(s.repository, request)
+	s.repository = append(s.repository, request.Copy())
+	s.index(len(s.repository) - 1)
 
-	err := s.SaveToDisk(s.repository)
+	return s.save()
+}
+
+// save writes the repository to disk. The caller must hold the lock.
+func (s *RequestStore) save() error {
+	s.saveErr = s.SaveToDisk(s.repository)
+	return s.saveErr
+}
 
-	return err
+// Ping returns the error from the last write to disk, so the store reports
+// itself unhealthy until a write succeeds again.
+func (s *RequestStore) Ping(ctx context.Context) error {
+	s.RLock()
+	defer s.RUnlock()
+	return s.saveErr
+}
+
+// Update ...
+func (s *RequestStore) Update(ctx context.Context, request *download.Request) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, r := range s.repository {
+		if r.ID == request.ID {
+			s.repository[i] = request.Copy()
+			if r.Tenant != request.Tenant || r.Owner != request.Owner || !reflect.DeepEqual(r.Labels, request.Labels) {
+				s.reindex()
+			}
+			return s.save()
+		}
+	}
+	return fmt.Errorf("unable to find request with id:%s", request.ID)
 }
 
 // FindByID ...
-func (s *RequestStore) FindByID(requestID string) (*download.Request, error) {
+func (s *RequestStore) FindByID(ctx context.Context, tenant string, requestID string) (*download.Request, error) {
 	s.RLock()
 	defer s.RUnlock()
-	for _, request := range s.repository {
-		if request.ID == requestID {
-			return request, nil
+	for _, i := range s.tenantIndex[tenant] {
+		if s.repository[i].ID == requestID {
+			return s.repository[i].Copy(), nil
 		}
 	}
 	return nil, nil
 }
 
 // FindByResourceKey ...
-func (s *RequestStore) FindByResourceKey(resourceKey download.ResourceKey, offset uint, count uint) ([]*download.Request, error) {
+func (s *RequestStore) FindByResourceKey(ctx context.Context, tenant string, resourceKey download.ResourceKey, offset uint, count uint) ([]*download.Request, error) {
 	s.RLock()
 	defer s.RUnlock()
-	results := make([]*download.Request, 0, len(s.repository))
-	for _, request := range s.repository {
-		if request.ResourceKey() == resourceKey {
-			results = append(results, request)
-		}
-	}
+	results := s.find(tenant, "", func(request *download.Request) bool {
+		return request.ResourceKey() == resourceKey
+	})
 	return results, nil
 }
 
+// FindByIdempotencyKey ...
+func (s *RequestStore) FindByIdempotencyKey(ctx context.Context, tenant string, submitter string, key string) (*download.Request, error) {
+	s.RLock()
+	defer s.RUnlock()
+	results := s.find(tenant, "", func(request *download.Request) bool {
+		return request.IdempotencyKey == key && request.Submitter == submitter
+	})
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results[0], nil
+}
+
+// FindByLabels ...
+func (s *RequestStore) FindByLabels(ctx context.Context, tenant string, owner string, selector download.LabelSelector, offset uint, count uint) ([]*download.Request, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	match := func(request *download.Request) bool {
+		return (owner == "" || request.Owner == owner) && selector.Matches(request.Labels)
+	}
+
+	pair, _ := selector.IndexPair()
+	if owner != "" && pair != "" {
+		return page(s.findAt(s.ownerLabelIndex[ownerLabelKey(tenant, owner, pair)], match), offset, count), nil
+	}
+	return page(s.find(tenant, pair, match), offset, count), nil
+}
+
+// FindByOwner ...
+func (s *RequestStore) FindByOwner(ctx context.Context, tenant string, owner string, offset uint, count uint) ([]*download.Request, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	results := s.find(tenant, "", func(request *download.Request) bool {
+		return request.Owner == owner
+	})
+	return page(results, offset, count), nil
+}
+
+func page(results []*download.Request, offset uint, count uint) []*download.Request {
+	if offset >= uint(len(results)) {
+		return results[:0]
+	}
+	results = results[offset:]
+	if count < uint(len(results)) {
+		results = results[:count]
+	}
+	return results
+}
+
 // FindAll ...
-func (s *RequestStore) FindAll(offset uint, count uint) ([]*download.Request, error) {
+func (s *RequestStore) FindAll(ctx context.Context, tenant string, offset uint, count uint) ([]*download.Request, error) {
 	s.RLock()
 	defer s.RUnlock()
 
-	tmpRepository := make([]*download.Request, len(s.repository), len(s.repository))
-	copy(tmpRepository, s.repository)
+	results := s.find(tenant, "", func(*download.Request) bool {
+		return true
+	})
+
+	return results, nil
+}
+
+// FindByStates returns requests in any of the states, from every tenant.
+func (s *RequestStore) FindByStates(ctx context.Context, states []string) ([]*download.Request, error) {
+	s.RLock()
+	defer s.RUnlock()
 
-	return tmpRepository, nil
+	results := make([]*download.Request, 0)
+	for _, request := range s.repository {
+		for _, state := range states {
+			if request.State == state {
+				results = append(results, request.Copy())
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// Close writes the store to disk one last time.
+func (s *RequestStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.save()
 }
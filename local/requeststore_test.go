@@ -0,0 +1,145 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// slowClient accepts every request after a short delay.
+type slowClient struct{}
+
+func (c *slowClient) ProcessRequest(ctx context.Context, r *download.Request) (*download.Download, error) {
+	time.Sleep(time.Millisecond)
+	return &download.Download{ID: "download-" + r.ID}, nil
+}
+
+// TestDispatchDoesNotShareRequests is meant to be run with -race: requests
+// are read through the store while dispatchers update them.
+func TestDispatchDoesNotShareRequests(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewRequestStore(filepath.Join(t.TempDir(), "requests.json"))
+	if err == nil {
+		t.Fatal("expected an error for a missing data file")
+	}
+
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("request-%d", i)
+		err = store.Add(ctx, &download.Request{ID: ids[i], URL: "http://example.com/", State: download.RequestQueued, Metadata: &download.Metadata{StatusCode: 200}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	box, err := download.NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := download.NewRequestService(store, &slowClient{}, box)
+	_, err = s.Resume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start(2)
+
+	for i := 0; i < 5; i++ {
+		for _, id := range ids {
+			r, err := s.FindByID(ctx, download.DefaultTenant, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			download.ToAPIRequest(r)
+			s.Cancel(ctx, download.DefaultTenant, id)
+		}
+	}
+
+	err = s.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		r, _ := store.FindByID(ctx, download.DefaultTenant, id)
+		if r.State != download.RequestDispatched && r.State != download.RequestCancelled {
+			t.Errorf("%s: expected dispatched or cancelled, got %s", id, r.State)
+		}
+		if (r.State == download.RequestDispatched) != (r.DownloadID != "") {
+			t.Errorf("%s: %s with download id '%s'", id, r.State, r.DownloadID)
+		}
+	}
+}
+
+func TestRequestStoreScopesTenants(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewRequestStore(filepath.Join(t.TempDir(), "requests.json"))
+
+	labels := map[string]string{"team": "a"}
+	for _, r := range []*download.Request{
+		{ID: "a", Tenant: "tenant-a", Owner: "alice", Labels: labels},
+		{ID: "b", Tenant: "tenant-b", Owner: "alice", Labels: labels},
+	} {
+		err := store.Add(ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := store.FindByID(ctx, "tenant-a", "b")
+	if err != nil || r != nil {
+		t.Errorf("FindByID: expected tenant-a not to find tenant-b's request, got %v %v", r, err)
+	}
+
+	selector, err := download.ParseLabelSelector("team=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, find := range map[string]func() ([]*download.Request, error){
+		"FindAll":      func() ([]*download.Request, error) { return store.FindAll(ctx, "tenant-a", 0, 100) },
+		"FindByLabels": func() ([]*download.Request, error) { return store.FindByLabels(ctx, "tenant-a", "", selector, 0, 100) },
+		"FindByOwner":  func() ([]*download.Request, error) { return store.FindByOwner(ctx, "tenant-a", "alice", 0, 100) },
+	} {
+		results, err := find()
+		if err != nil || len(results) != 1 || results[0].ID != "a" {
+			t.Errorf("%s: expected only tenant-a's request, got %v %v", name, results, err)
+		}
+	}
+}
+
+func TestFindByLabelsFiltersOwnersBeforeLimit(t *testing.T) {
+	store, _ := NewRequestStore(filepath.Join(t.TempDir(), "requests.json"))
+	ctx := context.Background()
+
+	labels := map[string]string{"team": "a"}
+	for _, r := range []*download.Request{
+		{ID: "b1", Tenant: "tenant-a", Owner: "bob", Labels: labels},
+		{ID: "b2", Tenant: "tenant-a", Owner: "bob", Labels: labels},
+		{ID: "a", Tenant: "tenant-a", Owner: "alice", Labels: labels},
+	} {
+		err := store.Add(ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, s := range []string{"team=a", "team"} {
+		selector, err := download.ParseLabelSelector(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := store.FindByLabels(ctx, "tenant-a", "alice", selector, 0, 1)
+		if err != nil || len(results) != 1 || results[0].ID != "a" {
+			t.Errorf("%s: expected alice's request, got %v %v", s, results, err)
+		}
+
+		results, err = store.FindByLabels(ctx, "tenant-a", "", selector, 0, 100)
+		if err != nil || len(results) != 3 {
+			t.Errorf("%s: expected every owner's requests, got %v %v", s, results, err)
+		}
+	}
+}
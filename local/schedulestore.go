@@ -0,0 +1,106 @@
+package local
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patdowney/downloaderd-common/local"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// ScheduleStore keeps schedules in memory and writes them all to a JSON
+// file on every change. It stores and returns copies, so a schedule being
+// run isn't shared with callers reading it.
+type ScheduleStore struct {
+	local.JSONStore
+	sync.RWMutex
+	repository []*download.Schedule
+}
+
+// NewScheduleStore ...
+func NewScheduleStore(dataFile string) (*ScheduleStore, error) {
+	scheduleStore := &ScheduleStore{
+		repository: make([]*download.Schedule, 0)}
+
+	scheduleStore.DataFile = dataFile
+
+	err := scheduleStore.LoadFromDisk(&scheduleStore.repository)
+
+	return scheduleStore, err
+}
+
+// Add ...
+func (s *ScheduleStore) Add(schedule *download.Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+	s.repository = append(s.repository, schedule.Copy())
+
+	return s.SaveToDisk(s.repository)
+}
+
+// Update ...
+func (s *ScheduleStore) Update(schedule *download.Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, sc := range s.repository {
+		if sc.ID == schedule.ID {
+			s.repository[i] = schedule.Copy()
+			return s.SaveToDisk(s.repository)
+		}
+	}
+	return fmt.Errorf("unable to find schedule with id:%s", schedule.ID)
+}
+
+// Delete ...
+func (s *ScheduleStore) Delete(schedule *download.Schedule) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, sc := range s.repository {
+		if sc.ID == schedule.ID {
+			s.repository = append(s.repository[:i], s.repository[i+1:]...)
+			return s.SaveToDisk(s.repository)
+		}
+	}
+	return nil
+}
+
+// FindByID ...
+func (s *ScheduleStore) FindByID(tenant string, scheduleID string) (*download.Schedule, error) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, schedule := range s.repository {
+		if schedule.ID == scheduleID && schedule.Tenant == tenant {
+			return schedule.Copy(), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindAll ...
+func (s *ScheduleStore) FindAll(tenant string, offset uint, count uint) ([]*download.Schedule, error) {
+	return s.find(func(schedule *download.Schedule) bool {
+		return schedule.Tenant == tenant
+	}), nil
+}
+
+// FindDue returns the schedules, from every tenant, whose next run is at
+// or before t.
+func (s *ScheduleStore) FindDue(t time.Time) ([]*download.Schedule, error) {
+	return s.find(func(schedule *download.Schedule) bool {
+		return !schedule.NextRun.IsZero() && !schedule.NextRun.After(t)
+	}), nil
+}
+
+func (s *ScheduleStore) find(match func(*download.Schedule) bool) []*download.Schedule {
+	s.RLock()
+	defer s.RUnlock()
+
+	results := make([]*download.Schedule, 0)
+	for _, schedule := range s.repository {
+		if match(schedule) {
+			results = append(results, schedule.Copy())
+		}
+	}
+	return results
+}
@@ -0,0 +1,95 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+func TestScheduleStoreScopesTenants(t *testing.T) {
+	store, _ := NewScheduleStore(filepath.Join(t.TempDir(), "schedules.json"))
+
+	now := time.Now()
+	for _, s := range []*download.Schedule{
+		{ID: "a", Tenant: "tenant-a", NextRun: now.Add(-time.Minute)},
+		{ID: "b", Tenant: "tenant-b", NextRun: now.Add(-time.Minute)},
+		{ID: "c", Tenant: "tenant-b", NextRun: now.Add(time.Minute)},
+	} {
+		err := store.Add(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := store.FindByID("tenant-a", "b")
+	if err != nil || s != nil {
+		t.Errorf("expected tenant-a not to find tenant-b's schedule, got %v %v", s, err)
+	}
+
+	all, err := store.FindAll("tenant-a", 0, 100)
+	if err != nil || len(all) != 1 || all[0].ID != "a" {
+		t.Errorf("expected only tenant-a's schedule, got %v %v", all, err)
+	}
+
+	due, err := store.FindDue(now)
+	if err != nil || len(due) != 2 {
+		t.Errorf("expected the due schedules of both tenants, got %v %v", due, err)
+	}
+}
+
+// TestRunScheduleWhileListing is meant to be run with -race: schedules are
+// listed and encoded while they are run.
+func TestRunScheduleWhileListing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	requestStore, _ := NewRequestStore(filepath.Join(t.TempDir(), "requests.json"))
+	scheduleStore, _ := NewScheduleStore(filepath.Join(t.TempDir(), "schedules.json"))
+
+	box, err := download.NewRandomSecretBox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestService := download.NewRequestService(requestStore, &slowClient{}, box)
+	requestService.MetadataClient = download.NewMetadataClient(download.NewRedirectPolicy(), nil)
+	s := download.NewScheduleService(scheduleStore, requestService)
+
+	_, err = s.AddSchedule(&download.Schedule{URL: server.URL, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			schedules, _ := scheduleStore.FindAll(download.DefaultTenant, 0, 100)
+			for _, schedule := range schedules {
+				err := s.Run(schedule)
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		schedules, err := s.ListAll(download.DefaultTenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(io.Discard).Encode(download.ToAPIScheduleList(&schedules))
+	}
+}
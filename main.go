@@ -1,69 +1,218 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"encoding/hex"
+	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"log/slog"
+	nethttp "net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/patdowney/downloaderd-common/http"
+	commonrethinkdb "github.com/patdowney/downloaderd-common/rethinkdb"
 	"github.com/patdowney/downloaderd-request/api"
 	"github.com/patdowney/downloaderd-request/download"
 	dh "github.com/patdowney/downloaderd-request/http"
 	"github.com/patdowney/downloaderd-request/local"
-	//	"github.com/patdowney/downloaderd-request/rethinkdb"
+	"github.com/patdowney/downloaderd-request/metrics"
+	"github.com/patdowney/downloaderd-request/rethinkdb"
+	"github.com/patdowney/downloaderd-request/tracing"
 )
 
-// Config ...
-type Config struct {
-	ListenAddress   string
-	RequestDataFile string
+// ConfigureLogging logs JSON at the configured level, with secrets in URLs
+// redacted.
+func ConfigureLogging(config *Config) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(config.LogLevel))
+	if err != nil {
+		fatal("init-logging-error", err)
+	}
 
-	DownloadServiceURL string
-	AccessLogWriter    io.Writer
-	ErrorLogWriter     io.Writer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(config.ErrorLogWriter, &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: download.RedactAttr})))
+}
 
-	RethinkDBAddress string
+// ConfigureTracing exports spans if a trace export file is configured.
+// Trace context is propagated to the download agent either way.
+func ConfigureTracing(config *Config) {
+	if config.TraceExportFile == "" {
+		return
+	}
+
+	exporter, err := tracing.OpenOTLPFileExporter(config.TraceExportFile, "downloaderd-request")
+	if err != nil {
+		fatal("init-tracing-error", err)
+	}
+	tracing.DefaultTracer.SetExporter(exporter, func(err error) {
+		slog.Error("trace-export-error", "error", err)
+	})
 }
 
-// ConfigureLogging ...
-func ConfigureLogging(config *Config) {
-	log.SetOutput(config.ErrorLogWriter)
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+// fatal logs an error that prevents the service from starting and exits.
+func fatal(event string, err error) {
+	slog.Error(event, "error", err)
+	os.Exit(1)
+}
+
+// NewSecretBox ...
+func NewSecretBox(keyFile string) (*download.SecretBox, error) {
+	if keyFile == "" {
+		slog.Warn("init-secret-box-no-key-file", "detail", "request secrets will not survive a restart")
+		return download.NewRandomSecretBox()
+	}
+
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(keyBytes)))
+	if err != nil {
+		return nil, err
+	}
+
+	return download.NewSecretBox(key)
+}
+
+// NewAddressGuard ...
+func NewAddressGuard(config *Config) (*download.AddressGuard, error) {
+	guard := download.NewAddressGuard()
+
+	allow, err := download.ParseCIDRs(strings.Split(config.AllowedCIDRs, ","))
+	if err != nil {
+		return nil, err
+	}
+	guard.Allow = allow
+
+	deny, err := download.ParseCIDRs(strings.Split(config.DeniedCIDRs, ","))
+	if err != nil {
+		return nil, err
+	}
+	guard.Deny = append(guard.Deny, deny...)
+
+	return guard, nil
+}
+
+// RequestStore is implemented by every request store backend.
+type RequestStore interface {
+	download.RequestStore
+	download.Pinger
+	io.Closer
+}
+
+// NewRequestStore creates the configured backend. The local store is
+// returned even with an error, which it returns if its data file couldn't
+// be loaded.
+func NewRequestStore(config *Config) (RequestStore, error) {
+	if config.StoreBackend == "rethinkdb" {
+		store, err := rethinkdb.NewRequestStore(commonrethinkdb.Config{
+			Address:  config.RethinkDBAddress,
+			MaxIdle:  config.RethinkDBMaxIdle,
+			MaxOpen:  config.RethinkDBMaxOpen,
+			Database: config.RethinkDBDatabase})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return local.NewRequestStore(config.RequestDataFile)
+}
+
+// NewScheduleStore creates the configured backend. A local data file that
+// doesn't exist yet is not an error.
+func NewScheduleStore(config *Config) (download.ScheduleStore, error) {
+	if config.StoreBackend == "rethinkdb" {
+		store, err := rethinkdb.NewScheduleStore(commonrethinkdb.Config{
+			Address:  config.RethinkDBAddress,
+			MaxIdle:  config.RethinkDBMaxIdle,
+			MaxOpen:  config.RethinkDBMaxOpen,
+			Database: config.RethinkDBDatabase})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	store, err := local.NewScheduleStore(config.ScheduleDataFile)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	return store, err
+}
+
+// NewAuthentication returns nil if no API keys or JWKS are configured, in
+// which case the request api is open to anyone.
+func NewAuthentication(config *Config) (*dh.Authentication, error) {
+	var authenticators []dh.Authenticator
+
+	if config.APIKeyFile != "" {
+		apiKeys, err := dh.LoadAPIKeys(config.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, apiKeys)
+	}
+
+	if config.JWKSFile != "" {
+		jwt, err := dh.LoadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		jwt.Issuer = config.JWTIssuer
+		jwt.Audience = config.JWTAudience
+		authenticators = append(authenticators, jwt)
+	}
+
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return dh.NewAuthentication(authenticators...), nil
 }
 
-// ParseArgs ...
-func ParseArgs() *Config {
-	c := &Config{}
-	flag.StringVar(&c.ListenAddress, "http", "localhost:8090", "address to listen on")
-	flag.StringVar(&c.RethinkDBAddress, "rethinkdb", "localhost:28015", "address to connect to")
-	flag.StringVar(&c.DownloadServiceURL, "downloadurl", "http://localhost:8080/download/", "download agent service")
-	flag.StringVar(&c.RequestDataFile, "requestdata", "requests.json", "request database file")
-	flag.Parse()
+// NewRateLimits ...
+func NewRateLimits(config *Config) (*dh.RateLimits, error) {
+	read, err := dh.ParseRateLimit(config.ReadRateLimit)
+	if err != nil {
+		return nil, err
+	}
 
-	c.AccessLogWriter = os.Stdout
-	c.ErrorLogWriter = os.Stderr
+	write, err := dh.ParseRateLimit(config.WriteRateLimit)
+	if err != nil {
+		return nil, err
+	}
 
-	return c
+	rateLimits := dh.NewRateLimits(read, write)
+	rateLimits.Address, err = dh.ParseRateLimit(config.AddressRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	rateLimits.Routes, err = dh.ParseRouteRateLimits(config.RouteRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	return rateLimits, nil
 }
 
 // CreateServer ...
 func CreateServer(config *Config) {
 	s := http.NewServer(&http.Config{ListenAddress: config.ListenAddress}, os.Stdout)
+	s.Router.Use(dh.CorrelationIDs)
 
-	requestStore, err := local.NewRequestStore(config.RequestDataFile)
-	/*
-		c := rethinkdb.Config{Address: config.RethinkDBAddress,
-			MaxIdle:  10,
-			MaxOpen:  20,
-			Database: "Downloaderd"}
-
-		requestStore, err := rethinkdb.NewRequestStore(c)
-	*/
-	if err != nil {
-		log.Printf("init-request-store-error: %v", err)
+	store, storeErr := NewRequestStore(config)
+	if store == nil {
+		fatal("init-request-store-error", storeErr)
+	} else if storeErr != nil {
+		slog.Error("init-request-store-error", "error", storeErr)
 	}
+	requestStore := download.NewTracingRequestStore(download.NewMetricsRequestStore(store, config.StoreBackend), config.StoreBackend)
 
 	linkResolver := api.NewLinkResolver(s.Router)
 	linkResolver.DefaultScheme = "http"
@@ -71,21 +220,183 @@ func CreateServer(config *Config) {
 
 	downloadURL, _ := url.Parse(config.DownloadServiceURL)
 
-	downloadClient, _ := download.NewHTTPClient(downloadURL)
+	downloadClient := &download.HTTPClient{
+		URL:          downloadURL,
+		Client:       &nethttp.Client{Timeout: time.Duration(config.DispatchTimeout)},
+		Retries:      config.DispatchRetries,
+		RetryBackoff: time.Duration(config.DispatchRetryBackoff)}
+
+	secretBox, err := NewSecretBox(config.SecretKeyFile)
+	if err != nil {
+		fatal("init-secret-box-error", err)
+	}
+
+	requestService := download.NewRequestService(requestStore, downloadClient, secretBox)
+	addressGuard, err := NewAddressGuard(config)
+	if err != nil {
+		fatal("init-address-guard-error", err)
+	}
+
+	requestService.MetadataClient = download.NewMetadataClient(&download.RedirectPolicy{
+		MaxRedirects:   config.MaxRedirects,
+		AllowCrossHost: config.AllowCrossHostRedirects,
+		AllowDowngrade: config.AllowRedirectDowngrade}, addressGuard)
+	requestService.MetadataClient.Policies = requestService.Policy
+	requestService.MetadataClient.Client.Timeout = time.Duration(config.ProbeTimeout)
+	requestService.MetadataClient.MaxHostWait = time.Duration(config.MaxHostWait)
+	requestService.MetadataClient.HostLimiter = download.NewHostLimiter(config.MaxProbesPerHost, time.Duration(config.HostDelay))
+	requestService.HostLimiter = download.NewHostLimiter(config.MaxDispatchCallsPerHost, time.Duration(config.HostDelay))
+
+	if config.PolicyFile != "" {
+		requestService.PolicyFile, err = download.NewPolicyFile(config.PolicyFile)
+		if err != nil {
+			fatal("init-policy-error", err)
+		}
+	}
+
+	if config.TenantsFile != "" {
+		requestService.Tenants, err = download.LoadTenants(config.TenantsFile)
+		if err != nil {
+			fatal("init-tenants-error", err)
+		}
+	}
+
+	if config.QuotaRequestsPerDay > 0 || config.QuotaQueued > 0 || config.QuotaBytesPerDay > 0 {
+		requestService.Quotas.ClientQuota = &download.Quota{
+			MaxRequestsPerDay: config.QuotaRequestsPerDay,
+			MaxQueued:         config.QuotaQueued,
+			MaxBytesPerDay:    config.QuotaBytesPerDay}
+	}
+
+	metrics.NewGaugeFunc("downloaderd_queue_depth", "Requests waiting to be dispatched.", func() float64 {
+		return float64(requestService.QueueDepth())
+	})
+	s.Router.Handle("/metrics", metrics.Handler()).Methods("GET")
 
-	requestService := download.NewRequestService(requestStore, downloadClient)
+	readiness := download.NewReadiness(time.Duration(config.HealthTimeout))
+	readiness.Add("request_store", func(ctx context.Context) error {
+		// a missing data file is expected the first time the service runs
+		if storeErr != nil && !os.IsNotExist(storeErr) {
+			return fmt.Errorf("failed to initialize: %v", storeErr)
+		}
+		return store.Ping(ctx)
+	})
+	readiness.AddPinger("download_agent", downloadClient)
+	readiness.Add("dispatch_queue", requestService.BacklogCheck(config.MaxBacklog))
+	dh.NewHealthResource(readiness).RegisterRoutes(s.Router)
+
+	resumed, err := requestService.Resume(context.Background())
+	if err != nil {
+		slog.Error("init-resume-error", "error", err)
+	} else if resumed > 0 {
+		slog.Info("requests-resumed", "count", resumed)
+	}
+	requestService.Start(config.Dispatchers)
 
 	requestResource := dh.NewRequestResource(requestService, linkResolver)
+	requestResource.Authentication, err = NewAuthentication(config)
+	if err != nil {
+		fatal("init-authentication-error", err)
+	}
+	requestResource.RateLimits, err = NewRateLimits(config)
+	if err != nil {
+		fatal("init-rate-limit-error", err)
+	}
 	s.AddResource("/request", requestResource)
 
-	err = s.ListenAndServe()
-	log.Printf("init-listen-error: %v", err)
+	quotaResource := dh.NewQuotaResource(requestService)
+	quotaResource.Authentication = requestResource.Authentication
+	quotaResource.RateLimits = requestResource.RateLimits
+	s.AddResource("/quota", quotaResource)
+
+	scheduleStore, err := NewScheduleStore(config)
+	if err != nil {
+		fatal("init-schedule-store-error", err)
+	}
+
+	scheduleService := download.NewScheduleService(scheduleStore, requestService)
+	scheduleService.Start(time.Duration(config.ScheduleInterval))
+
+	scheduleResource := dh.NewScheduleResource(scheduleService, linkResolver)
+	scheduleResource.Authentication = requestResource.Authentication
+	scheduleResource.RateLimits = requestResource.RateLimits
+	s.AddResource("/schedule", scheduleResource)
+
+	server := &nethttp.Server{
+		Addr:         config.ListenAddress,
+		Handler:      dh.AccessLog(config.AccessLogWriter, s.Router),
+		ReadTimeout:  time.Duration(config.ReadTimeout),
+		WriteTimeout: time.Duration(config.WriteTimeout),
+		IdleTimeout:  time.Duration(config.IdleTimeout)}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if config.TLSCertFile != "" {
+			serveErr <- server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			serveErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err = <-serveErr:
+		slog.Error("init-listen-error", "error", err)
+	case sig := <-signals:
+		slog.Info("shutdown-started", "signal", sig.String())
+	}
+
+	Shutdown(time.Duration(config.ShutdownTimeout), server, scheduleService, requestService, store)
+}
+
+// Shutdown stops accepting calls, waits for those in progress and for
+// dispatches to finish, and flushes the store. Dispatches not finished
+// within timeout are interrupted, and the store is only closed once their
+// requests have been saved as queued, to be resumed on the next start.
+func Shutdown(timeout time.Duration, server *nethttp.Server, scheduleService *download.ScheduleService, requestService *download.RequestService, store io.Closer) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("shutdown-server-error", "error", err)
+	}
+
+	scheduleService.Stop()
+
+	err = requestService.Stop(ctx)
+	if err != nil {
+		slog.Warn("shutdown-dispatch-error", "error", err, "queued", requestService.QueueDepth())
+	}
+
+	err = store.Close()
+	if err != nil {
+		slog.Error("shutdown-store-error", "error", err)
+	}
+
+	slog.Info("shutdown-complete")
 }
 
 func main() {
-	config := ParseArgs()
+	config, printConfig, err := ParseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	if printConfig {
+		err = config.Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	ConfigureLogging(config)
+	ConfigureTracing(config)
 
 	CreateServer(config)
 }
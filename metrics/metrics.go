@@ -0,0 +1,366 @@
+// Package metrics implements the counters, gauges and histograms the
+// service exposes in the Prometheus text format, without depending on a
+// metrics framework.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit latencies from a millisecond to half a minute.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry ...
+type Registry struct {
+	sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// DefaultRegistry is used by the New* functions.
+var DefaultRegistry = NewRegistry()
+
+func (r *Registry) register(name string, c collector) {
+	r.Lock()
+	defer r.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every metric in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.Lock()
+	collectors := make([]collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		err := r.Write(rw)
+		if err != nil {
+			slog.Error("metrics-write-error", "error", err)
+		}
+	})
+}
+
+// Handler serves the default registry's metrics.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.Replace(help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], extra[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// vec holds a metric's children by label values.
+type vec struct {
+	sync.Mutex
+	name     string
+	help     string
+	labels   []string
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name string, help string, labels []string) vec {
+	return vec{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string)}
+}
+
+func (v *vec) child(labelValues []string, create func() interface{}) interface{} {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+
+	v.Lock()
+	defer v.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), labelValues...)
+	}
+	return c
+}
+
+// sortedKeys returns the children's keys in a stable order.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter ...
+type Counter struct {
+	sync.Mutex
+	value float64
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.Lock()
+	c.value += delta
+	c.Unlock()
+}
+
+// Inc ...
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value ...
+func (c *Counter) Value() float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.value
+}
+
+// CounterVec ...
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter with the default registry.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	DefaultRegistry.register(name, c)
+	return c
+}
+
+// With returns the counter for the label values.
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.child(labelValues, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.values[k]), formatFloat(c.children[k].(*Counter).Value()))
+	}
+}
+
+// Gauge ...
+type Gauge struct {
+	sync.Mutex
+	value float64
+}
+
+// Set ...
+func (g *Gauge) Set(v float64) {
+	g.Lock()
+	g.value = v
+	g.Unlock()
+}
+
+// Add ...
+func (g *Gauge) Add(delta float64) {
+	g.Lock()
+	g.value += delta
+	g.Unlock()
+}
+
+// Inc ...
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec ...
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Value ...
+func (g *Gauge) Value() float64 {
+	g.Lock()
+	defer g.Unlock()
+	return g.value
+}
+
+// GaugeVec ...
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec registers a gauge with the default registry.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labels)}
+	DefaultRegistry.register(name, g)
+	return g
+}
+
+// With returns the gauge for the label values.
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.child(labelValues, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.Lock()
+	defer g.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, k := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, g.values[k]), formatFloat(g.children[k].(*Gauge).Value()))
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are collected.
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge function with the default registry.
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	DefaultRegistry.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe ...
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// HistogramVec ...
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the default registry. Nil
+// buckets are DefaultBuckets.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets}
+	DefaultRegistry.register(name, h)
+	return h
+}
+
+// With returns the histogram for the label values.
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.child(labelValues, func() interface{} {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, k := range h.sortedKeys() {
+		child := h.children[k].(*Histogram)
+		values := h.values[k]
+
+		child.Lock()
+		for i, upper := range child.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(upper)), child.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), child.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(child.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), child.count)
+		child.Unlock()
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	DefaultRegistry = NewRegistry()
+
+	counter := NewCounterVec("test_requests_total", "Requests.", "outcome")
+	counter.With("queued").Inc()
+	counter.With("failed").Add(2)
+
+	histogram := NewHistogramVec("test_duration_seconds", "Latency.", []float64{0.1, 1}, "route")
+	histogram.With("index").Observe(0.05)
+	histogram.With("index").Observe(0.5)
+	histogram.With("index").Observe(5)
+
+	NewGaugeFunc("test_queue_depth", "Queue depth.", func() float64 { return 3 })
+
+	var b bytes.Buffer
+	err := DefaultRegistry.Write(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{outcome="failed"} 2`,
+		`test_requests_total{outcome="queued"} 1`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{route="index",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="index",le="1"} 2`,
+		`test_duration_seconds_bucket{route="index",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="index"} 5.55`,
+		`test_duration_seconds_count{route="index"} 3`,
+		"test_queue_depth 3",
+	}
+	for _, line := range expected {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expected line %q in:\n%s", line, b.String())
+		}
+	}
+}
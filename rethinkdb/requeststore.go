@@ -1,6 +1,7 @@
 package rethinkdb
 
 import (
+	"context"
 	r "github.com/dancannon/gorethink"
 	"github.com/patdowney/downloaderd-common/rethinkdb"
 	"github.com/patdowney/downloaderd-request/download"
@@ -11,13 +12,87 @@ type RequestStore struct {
 	rethinkdb.GeneralStore
 }
 
+// Every index is prefixed with the request's tenant so that queries can be
+// scoped to one tenant.
+func tenantField(row r.Term) r.Term {
+	return row.Field("Tenant").Default("")
+}
+
+// TenantIndex ...
+func TenantIndex(row r.Term) interface{} {
+	return tenantField(row)
+}
+
 // ResourceKeyIndex ...
 func ResourceKeyIndex(row r.Term) interface{} {
-	return []interface{}{row.Field("URL"), row.Field("Metadata").Field("ETag")}
+	resolvedURL := row.Field("ResolvedURL").Default("")
+	url := r.Branch(resolvedURL.Eq(""), row.Field("URL"), resolvedURL)
+	return []interface{}{tenantField(row), url, row.Field("Metadata").Field("ETag")}
+}
+
+// IdempotencyKeyIndex ...
+func IdempotencyKeyIndex(row r.Term) interface{} {
+	return []interface{}{tenantField(row), row.Field("Submitter"), row.Field("IdempotencyKey")}
+}
+
+// OwnerIndex ...
+func OwnerIndex(row r.Term) interface{} {
+	return []interface{}{tenantField(row), row.Field("Owner")}
+}
+
+// LabelsIndex indexes each of a request's labels as a 'key=value' pair.
+func LabelsIndex(row r.Term) interface{} {
+	return row.Field("Labels").Default(map[string]interface{}{}).CoerceTo("array").Map(func(pair r.Term) interface{} {
+		return []interface{}{tenantField(row), pair.Nth(0).Add("=").Add(pair.Nth(1))}
+	})
+}
+
+// OwnerLabelsIndex indexes each of a request's labels as a 'key=value' pair
+// of its owner.
+func OwnerLabelsIndex(row r.Term) interface{} {
+	return row.Field("Labels").Default(map[string]interface{}{}).CoerceTo("array").Map(func(pair r.Term) interface{} {
+		return []interface{}{tenantField(row), row.Field("Owner").Default(""), pair.Nth(0).Add("=").Add(pair.Nth(1))}
+	})
+}
+
+// StateIndex isn't scoped to a tenant, as it is only used to resume requests
+// at startup.
+func StateIndex(row r.Term) interface{} {
+	return row.Field("State").Default("")
 }
 
 func (s *RequestStore) createIndexes() error {
-	err := s.IndexCreateWithFunc("ResourceKey", ResourceKeyIndex)
+	err := s.IndexCreateWithFunc("Tenant", TenantIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.IndexCreateWithFunc("TenantResourceKey", ResourceKeyIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.IndexCreateWithFunc("IdempotencyKey", IdempotencyKeyIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.createMultiIndex("Labels", LabelsIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.createMultiIndex("OwnerLabels", OwnerLabelsIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.IndexCreateWithFunc("Owner", OwnerIndex)
+	if err != nil {
+		return err
+	}
+
+	err = s.IndexCreateWithFunc("State", StateIndex)
 	if err != nil {
 		return err
 	}
@@ -26,6 +101,27 @@ func (s *RequestStore) createIndexes() error {
 	return nil
 }
 
+func (s *RequestStore) createMultiIndex(name string, indexFunction interface{}) error {
+	var indexes []string
+	rows, err := s.BaseTerm().IndexList().Run(s.Session)
+	if err != nil {
+		return err
+	}
+	err = rows.All(&indexes)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if index == name {
+			return nil
+		}
+	}
+
+	_, err = s.BaseTerm().IndexCreateFunc(name, indexFunction, r.IndexCreateOpts{Multi: true}).RunWrite(s.Session)
+	return err
+}
+
 // Init ...
 func (s *RequestStore) Init() error {
 	return s.createIndexes()
@@ -63,32 +159,133 @@ func NewRequestStore(c rethinkdb.Config) (*RequestStore, error) {
 	return NewRequestStoreWithSession(session, c.Database, "RequestStore")
 }
 
+// Ping runs a trivial query to check the connection.
+func (s *RequestStore) Ping(ctx context.Context) error {
+	cursor, err := r.Expr(1).Run(s.Session, r.RunOpts{Context: ctx})
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
 // Add ...
-func (s *RequestStore) Add(request *download.Request) error {
+func (s *RequestStore) Add(ctx context.Context, request *download.Request) error {
 	err := s.Insert(request)
 	return err
 }
 
+// Update ...
+func (s *RequestStore) Update(ctx context.Context, request *download.Request) error {
+	_, err := s.Get(request.ID).Replace(request).RunWrite(s.Session)
+	return err
+}
+
 // FindByID ...
-func (s *RequestStore) FindByID(requestID string) (*download.Request, error) {
+func (s *RequestStore) FindByID(ctx context.Context, tenant string, requestID string) (*download.Request, error) {
 	idLookup := s.Get(requestID)
 
-	return s.getSingleRequest(idLookup)
+	request, err := s.getSingleRequest(idLookup)
+	if err != nil || request == nil || request.Tenant != tenant {
+		return nil, err
+	}
+	return request, nil
 }
 
 // FindByResourceKey ...
-func (s *RequestStore) FindByResourceKey(resourceKey download.ResourceKey, offset uint, count uint) ([]*download.Request, error) {
-	resourceKeyLookup := s.GetAllByIndex("ResourceKey", []interface{}{resourceKey.URL, resourceKey.ETag})
+func (s *RequestStore) FindByResourceKey(ctx context.Context, tenant string, resourceKey download.ResourceKey, offset uint, count uint) ([]*download.Request, error) {
+	resourceKeyLookup := s.GetAllByIndex("TenantResourceKey", []interface{}{tenant, resourceKey.URL, resourceKey.ETag})
 
 	return s.getMultiRequest(resourceKeyLookup, offset, count)
 }
 
+// FindByIdempotencyKey ...
+func (s *RequestStore) FindByIdempotencyKey(ctx context.Context, tenant string, submitter string, key string) (*download.Request, error) {
+	keyLookup := s.GetAllByIndex("IdempotencyKey", []interface{}{tenant, submitter, key})
+
+	results, err := s.getMultiRequest(keyLookup, 0, 1)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// FindByLabels ...
+func (s *RequestStore) FindByLabels(ctx context.Context, tenant string, owner string, selector download.LabelSelector, offset uint, count uint) ([]*download.Request, error) {
+	pair, ok := selector.IndexPair()
+
+	var term r.Term
+	switch {
+	case owner != "" && ok:
+		term = s.GetAllByIndex("OwnerLabels", []interface{}{tenant, owner, pair})
+	case owner != "":
+		term = s.GetAllByIndex("Owner", []interface{}{tenant, owner})
+	case ok:
+		term = s.GetAllByIndex("Labels", []interface{}{tenant, pair})
+	default:
+		term = s.GetAllByIndex("Tenant", tenant)
+	}
+
+	for _, lr := range selector {
+		term = term.Filter(labelFilter(lr))
+	}
+
+	return s.getMultiRequest(term, offset, count)
+}
+
+// FindByOwner ...
+func (s *RequestStore) FindByOwner(ctx context.Context, tenant string, owner string, offset uint, count uint) ([]*download.Request, error) {
+	ownerLookup := s.GetAllByIndex("Owner", []interface{}{tenant, owner})
+
+	return s.getMultiRequest(ownerLookup, offset, count)
+}
+
+func labelFilter(lr download.LabelRequirement) func(r.Term) interface{} {
+	return func(row r.Term) interface{} {
+		labels := row.Field("Labels").Default(map[string]interface{}{})
+		var match r.Term
+		if lr.Exists {
+			match = labels.HasFields(lr.Key)
+		} else {
+			match = labels.Field(lr.Key).Default(nil).Eq(lr.Value)
+		}
+		if lr.Negate {
+			return match.Not()
+		}
+		return match
+	}
+}
+
 // FindAll ...
-func (s *RequestStore) FindAll(offset uint, count uint) ([]*download.Request, error) {
-	allLookup := s.BaseTerm()
+func (s *RequestStore) FindAll(ctx context.Context, tenant string, offset uint, count uint) ([]*download.Request, error) {
+	allLookup := s.GetAllByIndex("Tenant", tenant)
 	return s.getMultiRequest(allLookup, offset, count)
 }
 
+// FindByStates returns requests in any of the states, from every tenant.
+func (s *RequestStore) FindByStates(ctx context.Context, states []string) ([]*download.Request, error) {
+	keys := make([]interface{}, len(states))
+	for i, state := range states {
+		keys[i] = state
+	}
+
+	rows, err := s.BaseTerm().GetAllByIndex("State", keys...).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*download.Request
+	err = rows.All(&results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Close closes the session.
+func (s *RequestStore) Close() error {
+	return s.Session.Close()
+}
+
 func (s *RequestStore) getMultiRequest(term r.Term, offset uint, count uint) ([]*download.Request, error) {
 	var results []*download.Request
 
@@ -0,0 +1,122 @@
+package rethinkdb
+
+import (
+	"time"
+
+	r "github.com/dancannon/gorethink"
+	"github.com/patdowney/downloaderd-common/rethinkdb"
+	"github.com/patdowney/downloaderd-request/download"
+)
+
+// ScheduleStore ...
+type ScheduleStore struct {
+	rethinkdb.GeneralStore
+}
+
+// NewScheduleStoreWithSession ...
+func NewScheduleStoreWithSession(s *r.Session, dbName string, tableName string) (*ScheduleStore, error) {
+
+	generalStore, err := rethinkdb.NewGeneralStoreWithSession(s, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduleStore := &ScheduleStore{}
+	scheduleStore.GeneralStore = *generalStore
+
+	return scheduleStore, nil
+}
+
+// NewScheduleStore ...
+func NewScheduleStore(c rethinkdb.Config) (*ScheduleStore, error) {
+	session, err := r.Connect(r.ConnectOpts{
+		Address: c.Address,
+		MaxIdle: c.MaxIdle,
+		MaxOpen: c.MaxOpen,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewScheduleStoreWithSession(session, c.Database, "ScheduleStore")
+}
+
+// Add ...
+func (s *ScheduleStore) Add(schedule *download.Schedule) error {
+	return s.Insert(schedule)
+}
+
+// Update ...
+func (s *ScheduleStore) Update(schedule *download.Schedule) error {
+	_, err := s.Get(schedule.ID).Replace(schedule).RunWrite(s.Session)
+	return err
+}
+
+// Delete ...
+func (s *ScheduleStore) Delete(schedule *download.Schedule) error {
+	_, err := s.Get(schedule.ID).Delete().RunWrite(s.Session)
+	return err
+}
+
+// FindByID ...
+func (s *ScheduleStore) FindByID(tenant string, scheduleID string) (*download.Schedule, error) {
+	row, err := s.Get(scheduleID).Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	if row.IsNil() {
+		return nil, nil
+	}
+
+	var schedule download.Schedule
+	err = row.One(&schedule)
+	if err != nil || schedule.Tenant != tenant {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// FindAll ...
+func (s *ScheduleStore) FindAll(tenant string, offset uint, count uint) ([]*download.Schedule, error) {
+	tenantLookup := s.BaseTerm().Filter(func(row r.Term) interface{} {
+		return row.Field("Tenant").Default("").Eq(tenant)
+	})
+	return s.getMultiSchedule(tenantLookup.Slice(offset, (offset + count)))
+}
+
+// FindDue returns the schedules, from every tenant, whose next run is at
+// or before t.
+func (s *ScheduleStore) FindDue(t time.Time) ([]*download.Schedule, error) {
+	dueLookup := s.BaseTerm().Filter(func(row r.Term) interface{} {
+		return row.Field("NextRun").Le(t)
+	})
+	schedules, err := s.getMultiSchedule(dueLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*download.Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		if !schedule.NextRun.IsZero() {
+			results = append(results, schedule)
+		}
+	}
+	return results, nil
+}
+
+func (s *ScheduleStore) getMultiSchedule(term r.Term) ([]*download.Schedule, error) {
+	var results []*download.Schedule
+
+	rows, err := term.Run(s.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rows.All(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ScopeName identifies the instrumentation in exported spans.
+const ScopeName = "github.com/patdowney/downloaderd-request"
+
+// OTLP status codes.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTracesData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func toOTLPValue(v interface{}) otlpValue {
+	switch t := v.(type) {
+	case string:
+		return otlpValue{StringValue: &t}
+	case bool:
+		return otlpValue{BoolValue: &t}
+	case int:
+		s := strconv.Itoa(t)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(t, 10)
+		return otlpValue{IntValue: &s}
+	case uint:
+		s := strconv.FormatUint(uint64(t), 10)
+		return otlpValue{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(t, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &t}
+	}
+	s := fmt.Sprint(v)
+	return otlpValue{StringValue: &s}
+}
+
+func toOTLPSpan(data *SpanData) otlpSpan {
+	span := otlpSpan{
+		TraceID:           data.Context.TraceID.String(),
+		SpanID:            data.Context.SpanID.String(),
+		Name:              data.Name,
+		Kind:              data.Kind,
+		StartTimeUnixNano: strconv.FormatInt(data.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(data.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusUnset}}
+
+	if data.Parent.IsValid() {
+		span.ParentSpanID = data.Parent.String()
+	}
+	for _, a := range data.Attributes {
+		span.Attributes = append(span.Attributes, otlpAttribute{Key: a.Key, Value: toOTLPValue(a.Value)})
+	}
+	if data.Error {
+		span.Status = otlpStatus{Code: otlpStatusError, Message: data.StatusMessage}
+	}
+	return span
+}
+
+// OTLPFileExporter writes each span as a line of OTLP JSON, the format of
+// the OpenTelemetry collector's file exporter and receiver.
+type OTLPFileExporter struct {
+	sync.Mutex
+	ServiceName string
+	w           io.Writer
+}
+
+// NewOTLPFileExporter ...
+func NewOTLPFileExporter(w io.Writer, serviceName string) *OTLPFileExporter {
+	return &OTLPFileExporter{ServiceName: serviceName, w: w}
+}
+
+// OpenOTLPFileExporter appends spans to the file at path, or writes them
+// to stdout if path is "-".
+func OpenOTLPFileExporter(path string, serviceName string) (*OTLPFileExporter, error) {
+	if path == "-" {
+		return NewOTLPFileExporter(os.Stdout, serviceName), nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewOTLPFileExporter(file, serviceName), nil
+}
+
+// Export ...
+func (e *OTLPFileExporter) Export(data *SpanData) error {
+	serviceName := e.ServiceName
+	traces := otlpTracesData{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttribute{
+				{Key: "service.name", Value: otlpValue{StringValue: &serviceName}}}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: ScopeName},
+				Spans: []otlpSpan{toOTLPSpan(data)}}}}}}
+
+	line, err := json.Marshal(traces)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
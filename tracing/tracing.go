@@ -0,0 +1,333 @@
+// Package tracing records spans and propagates them as W3C trace context,
+// without depending on a tracing framework. Spans are exported in the
+// OpenTelemetry (OTLP) JSON format.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader propagates the span context of a call.
+const TraceparentHeader = "traceparent"
+
+// TraceID ...
+type TraceID [16]byte
+
+// IsValid returns false for the all-zero id.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID ...
+type SpanID [8]byte
+
+// IsValid returns false for the all-zero id.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid ...
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header value,
+// or returns "" if it isn't valid.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	// version 00 has exactly four fields, later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+
+	var flags [1]byte
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		decodeHex(sc.TraceID[:], parts[1]) != nil ||
+		decodeHex(sc.SpanID[:], parts[2]) != nil ||
+		decodeHex(flags[:], parts[3]) != nil {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex accepts only lower case hex, as the W3C format requires.
+func decodeHex(dst []byte, s string) error {
+	if strings.ToLower(s) != s {
+		return fmt.Errorf("upper case hex")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// SpanKind values match OTLP's.
+type SpanKind int
+
+// Span kinds.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attribute ...
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span, as passed to an Exporter.
+type SpanData struct {
+	Name          string
+	Kind          SpanKind
+	Context       SpanContext
+	Parent        SpanID
+	Start         time.Time
+	End           time.Time
+	Attributes    []Attribute
+	Error         bool
+	StatusMessage string
+}
+
+// Span records one operation. A nil *Span does nothing, so callers don't
+// need to check whether tracing is enabled.
+type Span struct {
+	sync.Mutex
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span's SpanContext.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute records a string, bool, integer or float value.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed if err isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Error = true
+	s.data.StatusMessage = err.Error()
+}
+
+// End finishes the span and exports it if it is sampled. Only the first
+// call has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.export(&data)
+	}
+}
+
+// Exporter ...
+type Exporter interface {
+	Export(*SpanData) error
+}
+
+// Tracer starts spans and passes them to its Exporter when they end.
+// Without an Exporter spans are still created, so trace context is
+// propagated, but they aren't recorded.
+type Tracer struct {
+	sync.Mutex
+	Exporter Exporter
+	OnError  func(error)
+}
+
+// DefaultTracer is used by Start.
+var DefaultTracer = &Tracer{}
+
+func (t *Tracer) export(data *SpanData) {
+	t.Lock()
+	exporter := t.Exporter
+	onError := t.OnError
+	t.Unlock()
+
+	if exporter == nil {
+		return
+	}
+	err := exporter.Export(data)
+	if err != nil && onError != nil {
+		onError(err)
+	}
+}
+
+// SetExporter ...
+func (t *Tracer) SetExporter(exporter Exporter, onError func(error)) {
+	t.Lock()
+	defer t.Unlock()
+	t.Exporter = exporter
+	t.OnError = onError
+}
+
+// Start starts a span that is a child of the span, or remote span context,
+// in ctx, and returns a context containing it.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		randomID(sc.TraceID[:])
+	}
+	randomID(sc.SpanID[:])
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			Context: sc,
+			Parent:  parent.SpanID,
+			Start:   time.Now()}}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Start starts a span with the DefaultTracer.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return DefaultTracer.Start(ctx, name, kind)
+}
+
+func randomID(id []byte) {
+	_, err := rand.Read(id)
+	if err != nil {
+		// an invalid id is better than a panic; the span won't propagate
+		for i := range id {
+			id[i] = 0
+		}
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan ...
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context whose spans are children
+// of a span in another process, or saved earlier.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the SpanContext of the current span, or
+// the remote span context if there is no current span.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithTraceparent parses traceparent and returns a context
+// containing it as the remote span context. Invalid values are ignored.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Traceparent returns the traceparent of the span context in ctx, or "".
+func Traceparent(ctx context.Context) string {
+	return SpanContextFromContext(ctx).Traceparent()
+}
+
+// Inject sets the traceparent header from ctx.
+func Inject(ctx context.Context, header http.Header) {
+	if traceparent := Traceparent(ctx); traceparent != "" {
+		header.Set(TraceparentHeader, traceparent)
+	}
+}
+
+// Extract returns a context containing the span context in header.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return ContextWithTraceparent(ctx, header.Get(TraceparentHeader))
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	if sc.Traceparent() != valid {
+		t.Errorf("expected %s, got %s", valid, sc.Traceparent())
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for _, s := range invalid {
+		_, err := ParseTraceparent(s)
+		if err == nil {
+			t.Errorf("expected error parsing '%s'", s)
+		}
+	}
+}
+
+func TestSpansContinueIncomingTrace(t *testing.T) {
+	var out bytes.Buffer
+	tracer := &Tracer{Exporter: NewOTLPFileExporter(&out, "test")}
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := Extract(context.Background(), header)
+
+	ctx, span := tracer.Start(ctx, "parent", KindServer)
+	_, child := tracer.Start(ctx, "child", KindClient)
+	child.SetError(errors.New("failed"))
+	child.End()
+	span.End()
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if outgoing.Get(TraceparentHeader) != span.Context().Traceparent() {
+		t.Errorf("expected the current span to be injected, got %s", outgoing.Get(TraceparentHeader))
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(lines))
+	}
+
+	var exported otlpTracesData
+	err := json.Unmarshal(lines[0], &exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := exported.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.Name != "child" || s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != span.Context().SpanID.String() {
+		t.Errorf("unexpected child span %+v", s)
+	}
+	if s.Status.Code != otlpStatusError {
+		t.Errorf("expected error status, got %d", s.Status.Code)
+	}
+}